@@ -0,0 +1,164 @@
+package rpc
+
+import (
+    "context"
+    "crypto/tls"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// BalancedClient 将调用分摊到多个后端地址, 连接失败的后端会被暂时摘除
+type BalancedClient struct {
+    CallOptions
+    Balancer        Balancer
+    PoolSize        int
+    PoolPolicy      PoolPolicy
+    TLSConfig       *tls.Config
+    EjectBackoff    time.Duration
+    MaxEjectBackoff time.Duration
+    Hedge           map[string]*HedgePolicy
+    servant         *Servant
+    mutex           sync.RWMutex
+    backends        []*Backend
+    latencies       map[string]*latencyTracker
+}
+
+func NewBalancedClient(addrs ...string) *BalancedClient {
+    c := &BalancedClient{
+        Balancer:        RoundRobin(),
+        PoolSize:        1,
+        EjectBackoff:    time.Second,
+        MaxEjectBackoff: time.Minute,
+        servant:         NewServant(),
+    }
+    c.servant.Register(serviceInvalidate, c.CallOptions.handleInvalidate)
+    endpoints := make([]Endpoint, 0, len(addrs))
+    for _, addr := range addrs {
+        endpoints = append(endpoints, Endpoint{Addr: addr, Weight: 1})
+    }
+    c.SetEndpoints(endpoints)
+    return c
+}
+
+// SetEndpoints 替换后端列表, 保留仍存在的后端的连接和状态
+func (c *BalancedClient) SetEndpoints(endpoints []Endpoint) {
+    c.mutex.Lock()
+    old := make(map[string]*Backend, len(c.backends))
+    for _, b := range c.backends {
+        old[b.Addr] = b
+    }
+    backends := make([]*Backend, 0, len(endpoints))
+    for _, ep := range endpoints {
+        if b, ok := old[ep.Addr]; ok {
+            if b.Weight != ep.Weight {
+                // Balancer 不加锁读取权重, 权重变化时换成新的 Backend 而不是原地修改
+                b = b.withEndpoint(ep)
+            }
+            backends = append(backends, b)
+            delete(old, ep.Addr)
+            continue
+        }
+        backends = append(backends, c.newBackend(ep))
+    }
+    c.backends = backends
+    c.mutex.Unlock()
+
+    for _, b := range old {
+        b.client.Close()
+    }
+}
+
+func (c *BalancedClient) Endpoints() []Endpoint {
+    c.mutex.RLock()
+    defer c.mutex.RUnlock()
+    result := make([]Endpoint, 0, len(c.backends))
+    for _, b := range c.backends {
+        result = append(result, b.Endpoint)
+    }
+    return result
+}
+
+func (c *BalancedClient) Backends() []*Backend {
+    c.mutex.RLock()
+    defer c.mutex.RUnlock()
+    return append([]*Backend(nil), c.backends...)
+}
+
+func (c *BalancedClient) newBackend(ep Endpoint) *Backend {
+    cli := NewP2PClient(ep.Addr)
+    cli.PoolSize = c.PoolSize
+    cli.PoolPolicy = c.PoolPolicy
+    cli.TLSConfig = c.TLSConfig
+    cli.servant = c.servant
+    return &Backend{
+        Endpoint:    ep,
+        client:      cli,
+        outstanding: new(int64),
+    }
+}
+
+func (c *BalancedClient) Call(ctx context.Context, service string, args interface{}, reply interface{}) error {
+    return c.invoke(ctx, service, args, reply, c.call)
+}
+
+func (c *BalancedClient) call(ctx context.Context, service string, args interface{}, reply interface{}) error {
+    if policy, ok := c.Hedge[service]; ok && policy != nil {
+        return c.hedgedCall(ctx, policy, service, args, reply)
+    }
+    b, err := c.pick(PickInfo{Ctx: ctx, Service: service, Args: args})
+    if err != nil {
+        return err
+    }
+    return c.callBackend(ctx, b, service, args, reply)
+}
+
+func (c *BalancedClient) callBackend(ctx context.Context, b *Backend, service string, args interface{}, reply interface{}) error {
+    err := c.Breaker.do(endpointBreakerName(b.Addr), func() error {
+        atomic.AddInt64(b.outstanding, 1)
+        defer atomic.AddInt64(b.outstanding, -1)
+        return b.client.Call(ctx, service, args, reply)
+    })
+    if isConnFailure(err) {
+        c.eject(b)
+    } else {
+        c.restore(b)
+    }
+    return err
+}
+
+func (c *BalancedClient) pick(info PickInfo) (*Backend, error) {
+    return c.pickExcluding(info, nil)
+}
+
+// eject 摘除后端, 连续失败时摘除时间指数增长
+func (c *BalancedClient) eject(b *Backend) {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    backoff := c.EjectBackoff
+    for i := 0; i < b.failures && backoff < c.MaxEjectBackoff; i++ {
+        backoff *= 2
+    }
+    if c.MaxEjectBackoff > 0 && backoff > c.MaxEjectBackoff {
+        backoff = c.MaxEjectBackoff
+    }
+    b.failures++
+    b.ejectedUntil = time.Now().Add(backoff)
+}
+
+func (c *BalancedClient) restore(b *Backend) {
+    b.mutex.Lock()
+    b.failures = 0
+    b.ejectedUntil = time.Time{}
+    b.mutex.Unlock()
+}
+
+func (c *BalancedClient) Register(serviceName string, i interface{}) bool {
+    return c.servant.Register(serviceName, i)
+}
+
+func (c *BalancedClient) Close() {
+    for _, b := range c.Backends() {
+        b.client.Close()
+    }
+}
@@ -0,0 +1,181 @@
+package rpc
+
+import (
+    "context"
+    "net"
+    "strconv"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// startNamedServers 启动 n 个服务器, who 服务返回服务器序号
+func startNamedServers(t *testing.T, n int) []string {
+    addrs := make([]string, n)
+    for i := range addrs {
+        name := strconv.Itoa(i)
+        _, addrs[i] = startServer(t, func(s *Server) {
+            s.Register("who", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+                reply.Name = name
+                return nil
+            })
+        })
+    }
+    return addrs
+}
+
+func closedAddr(t *testing.T) string {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    addr := ln.Addr().String()
+    ln.Close()
+    return addr
+}
+
+func newTestBalancedClient(t *testing.T, addrs ...string) *BalancedClient {
+    c := NewBalancedClient(addrs...)
+    c.SetLogger(NopLogger())
+    t.Cleanup(c.Close)
+    return c
+}
+
+func TestWeightedPick(t *testing.T) {
+    backends := []*Backend{
+        {Endpoint: Endpoint{Addr: "a", Weight: 5}},
+        {Endpoint: Endpoint{Addr: "b", Weight: 1}},
+        {Endpoint: Endpoint{Addr: "c", Weight: 0}},
+    }
+    w := Weighted()
+    counts := make(map[string]int)
+    for i := 0; i < 70; i++ {
+        counts[w.Pick(PickInfo{}, backends).Addr]++
+    }
+    // 权重 0 按 1 计算
+    if counts["a"] != 50 || counts["b"] != 10 || counts["c"] != 10 {
+        t.Fatalf("counts = %v", counts)
+    }
+}
+
+func TestBalancedRoundRobin(t *testing.T) {
+    addrs := startNamedServers(t, 3)
+    c := newTestBalancedClient(t, addrs...)
+    counts := make(map[string]int)
+    for i := 0; i < 30; i++ {
+        var reply echoArgs
+        if err := c.Call(context.Background(), "who", &echoArgs{}, &reply); err != nil {
+            t.Fatal(err)
+        }
+        counts[reply.Name]++
+    }
+    for i := 0; i < 3; i++ {
+        if n := counts[strconv.Itoa(i)]; n != 10 {
+            t.Fatalf("counts = %v", counts)
+        }
+    }
+}
+
+func TestBalancedEjectsDeadBackend(t *testing.T) {
+    addrs := startNamedServers(t, 2)
+    dead := closedAddr(t)
+    c := newTestBalancedClient(t, append(addrs, dead)...)
+    failures := 0
+    for i := 0; i < 20; i++ {
+        var reply echoArgs
+        if err := c.Call(context.Background(), "who", &echoArgs{}, &reply); err != nil {
+            failures++
+        }
+    }
+    if failures > 1 {
+        t.Fatalf("%d calls failed, dead backend should be ejected after the first", failures)
+    }
+    for _, b := range c.Backends() {
+        if b.Ejected() != (b.Addr == dead) {
+            t.Fatalf("%s ejected = %v", b.Addr, b.Ejected())
+        }
+    }
+}
+
+// 进行中的调用在换权重后结束, 新后端的计数仍能回到 0
+func TestBalancedOutstandingAcrossWeightChange(t *testing.T) {
+    release := make(chan struct{})
+    addr, calls, _ := startGatedServer(t, release)
+    c := newTestBalancedClient(t, addr)
+    c.Balancer = LeastOutstanding()
+
+    var wg sync.WaitGroup
+    for i := 0; i < 3; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if err := c.Call(context.Background(), "slow", &echoArgs{}, &echoArgs{}); err != nil {
+                t.Error(err)
+            }
+        }()
+    }
+    eventually(t, time.Second, func() bool { return atomic.LoadInt32(calls) == 3 })
+    c.SetEndpoints([]Endpoint{{Addr: addr, Weight: 5}})
+    b := c.Backends()[0]
+    if b.Weight != 5 || b.Outstanding() != 3 {
+        t.Fatalf("backend = %+v, outstanding = %d", b.Endpoint, b.Outstanding())
+    }
+    close(release)
+    wg.Wait()
+    if n := c.Backends()[0].Outstanding(); n != 0 {
+        t.Fatalf("outstanding = %d after all calls finished", n)
+    }
+}
+
+func TestBalancedSetEndpointsWeight(t *testing.T) {
+    addrs := startNamedServers(t, 2)
+    c := newTestBalancedClient(t, addrs...)
+    c.Balancer = Weighted()
+
+    var wg sync.WaitGroup
+    for i := 0; i < 4; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for j := 0; j < 50; j++ {
+                if err := c.Call(context.Background(), "who", &echoArgs{}, &echoArgs{}); err != nil {
+                    t.Error(err)
+                    return
+                }
+            }
+        }()
+    }
+    done := make(chan struct{})
+    go func() {
+        wg.Wait()
+        close(done)
+    }()
+    for i := 1; ; i++ {
+        select {
+        case <-done:
+        default:
+            c.SetEndpoints([]Endpoint{{Addr: addrs[0], Weight: i%5 + 1}, {Addr: addrs[1], Weight: 1}})
+            time.Sleep(100 * time.Microsecond)
+            continue
+        }
+        break
+    }
+
+    c.SetEndpoints([]Endpoint{{Addr: addrs[0], Weight: 3}, {Addr: addrs[1], Weight: 1}})
+    counts := make(map[string]int)
+    for i := 0; i < 40; i++ {
+        var reply echoArgs
+        if err := c.Call(context.Background(), "who", &echoArgs{}, &reply); err != nil {
+            t.Fatal(err)
+        }
+        counts[reply.Name]++
+    }
+    // 平滑加权轮询保留了换权重前的进度, 允许差一次
+    if counts["0"] < 29 || counts["0"] > 31 {
+        t.Fatalf("counts = %v", counts)
+    }
+    if eps := c.Endpoints(); eps[0].Weight != 3 {
+        t.Fatalf("endpoints = %v", eps)
+    }
+}
@@ -0,0 +1,178 @@
+package rpc
+
+import (
+    "context"
+    "math/rand"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+type (
+    Endpoint struct {
+        Addr   string `json:"addr"`
+        Weight int    `json:"weight,omitempty"`
+    }
+    // PickInfo 描述一次待分配的调用
+    PickInfo struct {
+        Ctx     context.Context
+        Service string
+        Args    interface{}
+        // Backends 是包括被摘除和熔断在内的全部后端
+        Backends []*Backend
+    }
+    // Balancer 从健康的后端中选出一个处理调用, backends 不会为空, 返回 nil 表示没有合适的后端
+    Balancer interface {
+        Pick(info PickInfo, backends []*Backend) *Backend
+    }
+    // Backend 是 BalancedClient 中的一个后端地址
+    Backend struct {
+        Endpoint
+        client       *Client
+        outstanding  *int64
+        mutex        sync.Mutex
+        failures     int
+        ejectedUntil time.Time
+    }
+)
+
+func (b *Backend) Outstanding() int64 {
+    if b.outstanding == nil {
+        return 0
+    }
+    return atomic.LoadInt64(b.outstanding)
+}
+
+func (b *Backend) Ejected() bool {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    return time.Now().Before(b.ejectedUntil)
+}
+
+// withEndpoint 复制后端的连接和摘除状态, 未完成调用的计数与原后端共用, 进行中的调用结束时仍能正确减少
+func (b *Backend) withEndpoint(ep Endpoint) *Backend {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    return &Backend{
+        Endpoint:     ep,
+        client:       b.client,
+        outstanding:  b.outstanding,
+        failures:     b.failures,
+        ejectedUntil: b.ejectedUntil,
+    }
+}
+
+func (b *Backend) weight() int {
+    if b.Weight <= 0 {
+        return 1
+    }
+    return b.Weight
+}
+
+type roundRobin struct {
+    next uint32
+}
+
+func RoundRobin() Balancer {
+    return &roundRobin{}
+}
+
+func (r *roundRobin) Pick(info PickInfo, backends []*Backend) *Backend {
+    n := atomic.AddUint32(&r.next, 1)
+    return backends[int(n)%len(backends)]
+}
+
+type random struct {
+    mutex sync.Mutex
+    rand  *rand.Rand
+}
+
+func Random() Balancer {
+    return &random{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (r *random) intn(n int) int {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    return r.rand.Intn(n)
+}
+
+func (r *random) Pick(info PickInfo, backends []*Backend) *Backend {
+    return backends[r.intn(len(backends))]
+}
+
+type leastOutstanding struct {
+    next uint32
+}
+
+func LeastOutstanding() Balancer {
+    return &leastOutstanding{}
+}
+
+func (l *leastOutstanding) Pick(info PickInfo, backends []*Backend) *Backend {
+    n := int(atomic.AddUint32(&l.next, 1))
+    var best *Backend
+    for i := range backends {
+        b := backends[(n+i)%len(backends)]
+        if best == nil || b.Outstanding() < best.Outstanding() {
+            best = b
+        }
+    }
+    return best
+}
+
+type powerOfTwo struct {
+    random
+}
+
+// PowerOfTwo 随机取两个后端, 选择未完成调用较少的一个
+func PowerOfTwo() Balancer {
+    return &powerOfTwo{random{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}}
+}
+
+func (p *powerOfTwo) Pick(info PickInfo, backends []*Backend) *Backend {
+    if len(backends) == 1 {
+        return backends[0]
+    }
+    i := p.intn(len(backends))
+    j := p.intn(len(backends) - 1)
+    if j >= i {
+        j++
+    }
+    a, b := backends[i], backends[j]
+    if b.Outstanding() < a.Outstanding() {
+        return b
+    }
+    return a
+}
+
+type weighted struct {
+    mutex   sync.Mutex
+    current map[*Backend]int
+}
+
+// Weighted 按 Endpoint.Weight 平滑加权轮询
+func Weighted() Balancer {
+    return &weighted{current: make(map[*Backend]int)}
+}
+
+func (w *weighted) Pick(info PickInfo, backends []*Backend) *Backend {
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+    var (
+        best  *Backend
+        total int
+    )
+    alive := make(map[*Backend]int, len(backends))
+    for _, b := range backends {
+        cur := w.current[b] + b.weight()
+        alive[b] = cur
+        total += b.weight()
+        if best == nil || cur > alive[best] {
+            best = b
+        }
+    }
+    alive[best] -= total
+    w.current = alive
+    return best
+}
@@ -0,0 +1,341 @@
+package rpc
+
+import (
+    "sort"
+    "sync"
+    "time"
+)
+
+type BreakerState int
+
+const (
+    BreakerClosed BreakerState = iota
+    BreakerOpen
+    BreakerHalfOpen
+)
+
+const breakerBuckets = 10
+
+var (
+    ErrCircuitOpen = &Error{Code: CodeUnavailable, Message: "circuit breaker is open", unsent: true}
+)
+
+func (s BreakerState) String() string {
+    switch s {
+    case BreakerClosed:
+        return "closed"
+    case BreakerOpen:
+        return "open"
+    case BreakerHalfOpen:
+        return "half-open"
+    }
+    return "unknown"
+}
+
+type (
+    // BreakerPolicy 描述熔断条件
+    // 统计窗口内请求数达到 MinRequests 且错误率或慢调用率超过阈值时熔断,
+    // 熔断 OpenTimeout 后进入半开状态, 放行 HalfOpenRequests 个探测请求, 全部成功则恢复
+    BreakerPolicy struct {
+        Window           time.Duration
+        MinRequests      int
+        ErrorRate        float64
+        SlowCall         time.Duration
+        SlowCallRate     float64
+        OpenTimeout      time.Duration
+        HalfOpenRequests int
+        IsFailure        func(err error) bool
+    }
+    // CircuitBreaker 按服务名和后端地址分别维护熔断状态
+    CircuitBreaker struct {
+        Policy        BreakerPolicy
+        OnStateChange func(name string, from BreakerState, to BreakerState)
+        mutex         sync.Mutex
+        breakers      map[string]*breaker
+    }
+    // BreakerStats 是单个熔断器的统计
+    BreakerStats struct {
+        Name        string
+        State       BreakerState
+        Requests    int
+        Failures    int
+        Slow        int
+        Rejected    uint64
+        Transitions uint64
+    }
+    breaker struct {
+        name        string
+        cb          *CircuitBreaker
+        mutex       sync.Mutex
+        state       BreakerState
+        openedAt    time.Time
+        probes      int
+        probeOK     int
+        buckets     [breakerBuckets]breakerBucket
+        rejected    uint64
+        transitions uint64
+    }
+    breakerBucket struct {
+        start    time.Time
+        requests int
+        failures int
+        slow     int
+    }
+)
+
+func DefaultBreakerPolicy() BreakerPolicy {
+    return BreakerPolicy{
+        Window:           time.Second * 10,
+        MinRequests:      20,
+        ErrorRate:        0.5,
+        OpenTimeout:      time.Second * 5,
+        HalfOpenRequests: 1,
+    }
+}
+
+func NewCircuitBreaker(policy BreakerPolicy) *CircuitBreaker {
+    return &CircuitBreaker{
+        Policy:   policy,
+        breakers: make(map[string]*breaker),
+    }
+}
+
+func serviceBreakerName(service string) string {
+    return "service/" + service
+}
+
+func endpointBreakerName(addr string) string {
+    return "endpoint/" + addr
+}
+
+// State 返回指定名字的熔断状态, 名字为 service/<服务名> 或 endpoint/<地址>
+func (cb *CircuitBreaker) State(name string) BreakerState {
+    cb.mutex.Lock()
+    b, ok := cb.breakers[name]
+    cb.mutex.Unlock()
+    if !ok {
+        return BreakerClosed
+    }
+    b.mutex.Lock()
+    from, to, changed := b.refresh(time.Now())
+    state := b.state
+    b.mutex.Unlock()
+    b.notify(from, to, changed)
+    return state
+}
+
+func (cb *CircuitBreaker) Stats() []BreakerStats {
+    cb.mutex.Lock()
+    list := make([]*breaker, 0, len(cb.breakers))
+    for _, b := range cb.breakers {
+        list = append(list, b)
+    }
+    cb.mutex.Unlock()
+    result := make([]BreakerStats, 0, len(list))
+    now := time.Now()
+    for _, b := range list {
+        b.mutex.Lock()
+        from, to, changed := b.refresh(now)
+        st := BreakerStats{
+            Name:        b.name,
+            State:       b.state,
+            Rejected:    b.rejected,
+            Transitions: b.transitions,
+        }
+        st.Requests, st.Failures, st.Slow = b.totals(now)
+        b.mutex.Unlock()
+        b.notify(from, to, changed)
+        result = append(result, st)
+    }
+    sort.Slice(result, func(i, j int) bool {
+        return result[i].Name < result[j].Name
+    })
+    return result
+}
+
+func (cb *CircuitBreaker) get(name string) *breaker {
+    cb.mutex.Lock()
+    defer cb.mutex.Unlock()
+    b, ok := cb.breakers[name]
+    if !ok {
+        b = &breaker{name: name, cb: cb}
+        cb.breakers[name] = b
+    }
+    return b
+}
+
+// do 在熔断器允许时执行 fn 并记录结果, cb 为 nil 时直接执行
+func (cb *CircuitBreaker) do(name string, fn func() error) error {
+    if cb == nil {
+        return fn()
+    }
+    b := cb.get(name)
+    if !b.allow() {
+        return ErrCircuitOpen
+    }
+    start := time.Now()
+    err := fn()
+    b.record(err, time.Since(start))
+    return err
+}
+
+// available 判断名字对应的熔断器当前是否可能放行请求, 不占用半开探测名额
+func (cb *CircuitBreaker) available(name string) bool {
+    if cb == nil {
+        return true
+    }
+    return cb.State(name) != BreakerOpen
+}
+
+func (cb *CircuitBreaker) isFailure(err error) bool {
+    if err == nil {
+        return false
+    }
+    if cb.Policy.IsFailure != nil {
+        return cb.Policy.IsFailure(err)
+    }
+    switch CodeOf(err) {
+    case CodeUnknown, CodeDeadlineExceeded, CodeResourceExhausted, CodeInternal, CodeUnavailable, CodeDataLoss:
+        return true
+    }
+    return false
+}
+
+func (b *breaker) allow() bool {
+    b.mutex.Lock()
+    from, to, changed := b.refresh(time.Now())
+    allowed := true
+    switch b.state {
+    case BreakerOpen:
+        allowed = false
+    case BreakerHalfOpen:
+        if b.probes >= b.halfOpenLimit() {
+            allowed = false
+        } else {
+            b.probes++
+        }
+    }
+    if !allowed {
+        b.rejected++
+    }
+    b.mutex.Unlock()
+    b.notify(from, to, changed)
+    return allowed
+}
+
+func (b *breaker) halfOpenLimit() int {
+    if b.cb.Policy.HalfOpenRequests <= 0 {
+        return 1
+    }
+    return b.cb.Policy.HalfOpenRequests
+}
+
+func (b *breaker) notify(from BreakerState, to BreakerState, changed bool) {
+    if changed && b.cb.OnStateChange != nil {
+        b.cb.OnStateChange(b.name, from, to)
+    }
+}
+
+func (b *breaker) record(err error, elapsed time.Duration) {
+    failed := b.cb.isFailure(err)
+    slow := b.cb.Policy.SlowCall > 0 && elapsed >= b.cb.Policy.SlowCall
+    now := time.Now()
+
+    b.mutex.Lock()
+    var from, to BreakerState
+    changed := false
+    switch b.state {
+    case BreakerHalfOpen:
+        if failed || slow {
+            from, to, changed = b.transit(BreakerOpen, now)
+        } else {
+            b.probeOK++
+            if b.probeOK >= b.halfOpenLimit() {
+                from, to, changed = b.transit(BreakerClosed, now)
+            }
+        }
+    case BreakerClosed:
+        bk := b.bucket(now)
+        bk.requests++
+        if failed {
+            bk.failures++
+        }
+        if slow {
+            bk.slow++
+        }
+        if b.tripped(now) {
+            from, to, changed = b.transit(BreakerOpen, now)
+        }
+    }
+    b.mutex.Unlock()
+    b.notify(from, to, changed)
+}
+
+func (b *breaker) tripped(now time.Time) bool {
+    p := b.cb.Policy
+    requests, failures, slow := b.totals(now)
+    if requests == 0 || requests < p.MinRequests {
+        return false
+    }
+    if p.ErrorRate > 0 && float64(failures)/float64(requests) >= p.ErrorRate {
+        return true
+    }
+    if p.SlowCall > 0 && p.SlowCallRate > 0 && float64(slow)/float64(requests) >= p.SlowCallRate {
+        return true
+    }
+    return false
+}
+
+// refresh 在熔断超时后进入半开状态
+func (b *breaker) refresh(now time.Time) (BreakerState, BreakerState, bool) {
+    if b.state == BreakerOpen && now.Sub(b.openedAt) >= b.cb.Policy.OpenTimeout {
+        return b.transit(BreakerHalfOpen, now)
+    }
+    return b.state, b.state, false
+}
+
+func (b *breaker) transit(state BreakerState, now time.Time) (BreakerState, BreakerState, bool) {
+    from := b.state
+    b.state = state
+    b.transitions++
+    b.probes = 0
+    b.probeOK = 0
+    switch state {
+    case BreakerOpen:
+        b.openedAt = now
+    case BreakerClosed:
+        b.buckets = [breakerBuckets]breakerBucket{}
+    }
+    return from, state, true
+}
+
+func (b *breaker) bucketSize() time.Duration {
+    window := b.cb.Policy.Window
+    if window <= 0 {
+        window = time.Second * 10
+    }
+    return window / breakerBuckets
+}
+
+func (b *breaker) bucket(now time.Time) *breakerBucket {
+    size := b.bucketSize()
+    start := now.Truncate(size)
+    bk := &b.buckets[(start.UnixNano()/int64(size))%breakerBuckets]
+    if !bk.start.Equal(start) {
+        *bk = breakerBucket{start: start}
+    }
+    return bk
+}
+
+func (b *breaker) totals(now time.Time) (requests int, failures int, slow int) {
+    size := b.bucketSize()
+    for i := range b.buckets {
+        bk := &b.buckets[i]
+        if now.Sub(bk.start) < size*breakerBuckets {
+            requests += bk.requests
+            failures += bk.failures
+            slow += bk.slow
+        }
+    }
+    return
+}
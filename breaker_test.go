@@ -0,0 +1,139 @@
+package rpc
+
+import (
+    "context"
+    "errors"
+    "strconv"
+    "testing"
+    "time"
+)
+
+func TestBreakerTransitions(t *testing.T) {
+    const openTimeout = 20 * time.Millisecond
+    type step struct {
+        op      string // ok, fail, slow, wait
+        allowed bool
+        state   BreakerState
+    }
+    tests := []struct {
+        name   string
+        policy BreakerPolicy
+        steps  []step
+    }{
+        {
+            name:   "below min requests",
+            policy: BreakerPolicy{MinRequests: 4, ErrorRate: 0.5},
+            steps: []step{
+                {"fail", true, BreakerClosed},
+                {"fail", true, BreakerClosed},
+                {"fail", true, BreakerClosed},
+            },
+        },
+        {
+            name:   "error rate trips",
+            policy: BreakerPolicy{MinRequests: 4, ErrorRate: 0.5},
+            steps: []step{
+                {"ok", true, BreakerClosed},
+                {"ok", true, BreakerClosed},
+                {"fail", true, BreakerClosed},
+                {"fail", true, BreakerOpen},
+                {"ok", false, BreakerOpen},
+            },
+        },
+        {
+            name:   "slow calls trip",
+            policy: BreakerPolicy{MinRequests: 2, SlowCall: time.Millisecond, SlowCallRate: 1},
+            steps: []step{
+                {"slow", true, BreakerClosed},
+                {"slow", true, BreakerOpen},
+            },
+        },
+        {
+            name:   "half-open probes close",
+            policy: BreakerPolicy{MinRequests: 1, ErrorRate: 1, HalfOpenRequests: 2},
+            steps: []step{
+                {"fail", true, BreakerOpen},
+                {"wait", false, BreakerHalfOpen},
+                {"ok", true, BreakerHalfOpen},
+                {"ok", true, BreakerClosed},
+                {"ok", true, BreakerClosed},
+            },
+        },
+        {
+            name:   "half-open failure reopens",
+            policy: BreakerPolicy{MinRequests: 1, ErrorRate: 1},
+            steps: []step{
+                {"fail", true, BreakerOpen},
+                {"wait", false, BreakerHalfOpen},
+                {"fail", true, BreakerOpen},
+                {"ok", false, BreakerOpen},
+            },
+        },
+    }
+    failure := Errorf(CodeInternal, "failed")
+    for _, tt := range tests {
+        tt.policy.OpenTimeout = openTimeout
+        var transitions []BreakerState
+        cb := NewCircuitBreaker(tt.policy)
+        cb.OnStateChange = func(name string, from BreakerState, to BreakerState) {
+            transitions = append(transitions, to)
+        }
+        b := cb.get("test")
+        for i, s := range tt.steps {
+            if s.op == "wait" {
+                time.Sleep(openTimeout)
+            } else {
+                allowed := b.allow()
+                if allowed != s.allowed {
+                    t.Fatalf("%s step %d: allowed = %v", tt.name, i, allowed)
+                }
+                if allowed {
+                    switch s.op {
+                    case "fail":
+                        b.record(failure, 0)
+                    case "slow":
+                        b.record(nil, 2*time.Millisecond)
+                    default:
+                        b.record(nil, 0)
+                    }
+                }
+            }
+            if state := cb.State("test"); state != s.state {
+                t.Fatalf("%s step %d: state = %s, want %s", tt.name, i, state, s.state)
+            }
+        }
+        if len(transitions) == 0 && tt.steps[len(tt.steps)-1].state != BreakerClosed {
+            t.Errorf("%s: OnStateChange not called", tt.name)
+        }
+    }
+}
+
+func TestBreakerOpensForFailingEndpoints(t *testing.T) {
+    addrs := make([]string, 2)
+    for i := range addrs {
+        _, addrs[i] = startServer(t, func(s *Server) {
+            for j := 0; j < 5; j++ {
+                s.Register("fail"+strconv.Itoa(j), func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+                    return Errorf(CodeInternal, "failed")
+                })
+            }
+        })
+    }
+    c := newTestBalancedClient(t, addrs...)
+    c.Breaker = NewCircuitBreaker(BreakerPolicy{MinRequests: 2, ErrorRate: 1, OpenTimeout: time.Minute})
+
+    // 每个服务只调用一次, 服务熔断器不会打开, 两个后端各失败两次
+    for i := 0; i < 4; i++ {
+        if err := c.Call(context.Background(), "fail"+strconv.Itoa(i), &echoArgs{}, &echoArgs{}); CodeOf(err) != CodeInternal {
+            t.Fatalf("call %d: err = %v", i, err)
+        }
+    }
+    if err := c.Call(context.Background(), "fail4", &echoArgs{}, &echoArgs{}); !errors.Is(err, ErrCircuitOpen) {
+        t.Fatalf("err = %v, want ErrCircuitOpen", err)
+    }
+    for _, addr := range addrs {
+        if state := c.Breaker.State(endpointBreakerName(addr)); state != BreakerOpen {
+            t.Errorf("%s: state = %s", addr, state)
+        }
+    }
+}
@@ -0,0 +1,140 @@
+package rpc
+
+// addConn 成功时占用 waitGroup 的一个计数, 连接的协程启动后由调用方释放, 保证 Add 发生在 Shutdown 的 Wait 之前
+func (s *Server) addConn(cli *acceptClient) bool {
+    s.connMutex.Lock()
+    defer s.connMutex.Unlock()
+    if s.closed {
+        return false
+    }
+    s.conns[cli.peer.ConnID] = cli
+    s.waitGroup.Add(1)
+    return true
+}
+
+func (s *Server) removeConn(cli *acceptClient) {
+    id := cli.peer.ConnID
+    s.connMutex.Lock()
+    delete(s.conns, id)
+    for name, members := range s.groups {
+        delete(members, id)
+        if len(members) == 0 {
+            delete(s.groups, name)
+        }
+    }
+    s.connMutex.Unlock()
+}
+
+// Connections 返回当前所有已接入连接的快照
+func (s *Server) Connections() []Callable {
+    s.connMutex.RLock()
+    defer s.connMutex.RUnlock()
+    result := make([]Callable, 0, len(s.conns))
+    for _, cli := range s.conns {
+        result = append(result, cli)
+    }
+    return result
+}
+
+// Connection 按连接 id 查找已接入的连接
+func (s *Server) Connection(id uint64) (Callable, bool) {
+    s.connMutex.RLock()
+    defer s.connMutex.RUnlock()
+    cli, ok := s.conns[id]
+    if !ok {
+        return nil, false
+    }
+    return cli, true
+}
+
+// Broadcast 向所有连接推送通知, 数据只编码一次, 不等待写出
+func (s *Server) Broadcast(service string, args interface{}) error {
+    s.connMutex.RLock()
+    targets := make([]*Conn, 0, len(s.conns))
+    for _, cli := range s.conns {
+        targets = append(targets, cli.conn)
+    }
+    s.connMutex.RUnlock()
+    return notifyAll(targets, service, args)
+}
+
+// Join 将连接加入分组, 连接关闭时自动退出所有分组
+func (s *Server) Join(group string, caller Callable) bool {
+    p, ok := PeerOf(caller)
+    if !ok {
+        return false
+    }
+    s.connMutex.Lock()
+    defer s.connMutex.Unlock()
+    cli, ok := s.conns[p.ConnID]
+    if !ok {
+        return false
+    }
+    members, ok := s.groups[group]
+    if !ok {
+        members = make(map[uint64]*acceptClient)
+        s.groups[group] = members
+    }
+    members[p.ConnID] = cli
+    return true
+}
+
+func (s *Server) Leave(group string, caller Callable) {
+    p, ok := PeerOf(caller)
+    if !ok {
+        return
+    }
+    s.connMutex.Lock()
+    defer s.connMutex.Unlock()
+    members, ok := s.groups[group]
+    if !ok {
+        return
+    }
+    delete(members, p.ConnID)
+    if len(members) == 0 {
+        delete(s.groups, group)
+    }
+}
+
+// GroupMembers 返回分组内连接的快照
+func (s *Server) GroupMembers(group string) []Callable {
+    s.connMutex.RLock()
+    defer s.connMutex.RUnlock()
+    members := s.groups[group]
+    result := make([]Callable, 0, len(members))
+    for _, cli := range members {
+        result = append(result, cli)
+    }
+    return result
+}
+
+// GroupNotify 向分组内所有连接推送通知, 数据只编码一次
+func (s *Server) GroupNotify(group string, service string, args interface{}) error {
+    s.connMutex.RLock()
+    members := s.groups[group]
+    targets := make([]*Conn, 0, len(members))
+    for _, cli := range members {
+        targets = append(targets, cli.conn)
+    }
+    s.connMutex.RUnlock()
+    return notifyAll(targets, service, args)
+}
+
+func notifyAll(targets []*Conn, service string, args interface{}) error {
+    if len(targets) == 0 {
+        return nil
+    }
+    msg, err := buildNotify(service, args)
+    if err != nil {
+        return err
+    }
+    bin, err := makePkt(3, msg)
+    if err != nil {
+        return err
+    }
+    for _, conn := range targets {
+        // 每个连接有自己的推送队列, 慢的连接会被关闭, 不影响其它连接
+        conn.push(bin)
+    }
+    return nil
+}
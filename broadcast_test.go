@@ -0,0 +1,103 @@
+package rpc
+
+import (
+    "context"
+    "net"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestBroadcastAndGroups(t *testing.T) {
+    s, addr := startServer(t, func(s *Server) {
+        s.Register("join", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+            p, _ := PeerFromContext(ctx)
+            s.Join(args.Name, p.Callable)
+            return nil
+        })
+    })
+    var all, group int32
+    clients := make([]*Client, 3)
+    for i := range clients {
+        c := newTestClient(t, addr)
+        c.Register("news", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+            if args.Name == "all" {
+                atomic.AddInt32(&all, 1)
+            } else {
+                atomic.AddInt32(&group, 1)
+            }
+            return nil
+        })
+        clients[i] = c
+    }
+    for i, c := range clients {
+        name := "red"
+        if i == 2 {
+            name = "blue"
+        }
+        if err := c.Call(context.Background(), "join", &echoArgs{Name: name}, &echoArgs{}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if n := len(s.Connections()); n != 3 {
+        t.Fatalf("connections = %d", n)
+    }
+    if n := len(s.GroupMembers("red")); n != 2 {
+        t.Fatalf("red members = %d", n)
+    }
+    if err := s.Broadcast("news", &echoArgs{Name: "all"}); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.GroupNotify("red", "news", &echoArgs{Name: "red"}); err != nil {
+        t.Fatal(err)
+    }
+    eventually(t, time.Second, func() bool {
+        return atomic.LoadInt32(&all) == 3 && atomic.LoadInt32(&group) == 2
+    })
+
+    // 断开的连接自动离开分组
+    clients[0].Close()
+    eventually(t, time.Second, func() bool { return len(s.GroupMembers("red")) == 1 })
+}
+
+func TestBroadcastSlowPeer(t *testing.T) {
+    s, addr := startServer(t, nil)
+    // 只连接不读取的对端
+    stalled, err := net.Dial("tcp", addr)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer stalled.Close()
+
+    c := newTestClient(t, addr)
+    var got int32
+    c.Register("news", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+        atomic.AddInt32(&got, 1)
+        return nil
+    })
+    if err := c.Call(context.Background(), "echo", &echoArgs{}, &echoArgs{}); err != nil {
+        t.Fatal(err)
+    }
+    eventually(t, time.Second, func() bool { return len(s.Connections()) == 2 })
+
+    const count = 1000
+    args := &echoArgs{Name: strings.Repeat("x", 64<<10)}
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        for i := 0; i < count; i++ {
+            _ = s.Broadcast("news", args)
+            // 给正常的连接留出读取的时间
+            time.Sleep(100 * time.Microsecond)
+        }
+    }()
+    select {
+    case <-done:
+    case <-time.After(5 * time.Second):
+        t.Fatal("Broadcast blocked on a stalled peer")
+    }
+    // 慢的连接被关闭, 正常连接收到全部通知
+    eventually(t, 5*time.Second, func() bool { return len(s.Connections()) == 1 })
+    eventually(t, 5*time.Second, func() bool { return atomic.LoadInt32(&got) == count })
+}
@@ -0,0 +1,227 @@
+package rpc
+
+import (
+    "container/list"
+    "context"
+    "sync"
+    "time"
+)
+
+const (
+    serviceInvalidate = "rpc.invalidate"
+)
+
+type (
+    // ResponseCache 缓存只读服务的响应, 和 Coalescer 一样以服务名, 元数据和编码后的参数为键, 条目数超过 MaxEntries 时按 LRU 淘汰
+    // 只缓存 TTL 中设置了有效期的服务, 服务端可以通过 Server.Invalidate 推送失效通知, 失效通知清除参数相同的所有元数据的缓存
+    ResponseCache struct {
+        TTL        map[string]time.Duration
+        MaxEntries int
+        mutex      sync.Mutex
+        lru        *list.List
+        entries    map[string]*list.Element
+        gens       map[string]uint64
+        hits       uint64
+        misses     uint64
+    }
+    cacheEntry struct {
+        key     string
+        service string
+        args    string
+        data    RawMessage
+        expires time.Time
+    }
+    // invalidateRequest 是服务端推送的失效通知, Args 为空时清除该服务的所有缓存
+    invalidateRequest struct {
+        Service string
+        Args    []byte
+    }
+    CacheStats struct {
+        Entries int
+        Hits    uint64
+        Misses  uint64
+    }
+)
+
+func NewResponseCache(maxEntries int) *ResponseCache {
+    return &ResponseCache{
+        TTL:        make(map[string]time.Duration),
+        MaxEntries: maxEntries,
+    }
+}
+
+// SetTTL 为服务开启缓存, ttl <= 0 时关闭
+func (c *ResponseCache) SetTTL(service string, ttl time.Duration) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    if c.TTL == nil {
+        c.TTL = make(map[string]time.Duration)
+    }
+    if ttl <= 0 {
+        delete(c.TTL, service)
+        c.purge(service)
+        return
+    }
+    c.TTL[service] = ttl
+}
+
+func (c *ResponseCache) Stats() CacheStats {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    return CacheStats{Entries: len(c.entries), Hits: c.hits, Misses: c.misses}
+}
+
+// Invalidate 清除参数为 args 的缓存, args 为 nil 时清除该服务的所有缓存
+func (c *ResponseCache) Invalidate(service string, args interface{}) error {
+    if args == nil {
+        c.mutex.Lock()
+        c.purge(service)
+        c.mutex.Unlock()
+        return nil
+    }
+    data, err := marshalSorted(args)
+    if err != nil {
+        return err
+    }
+    c.remove(service, data)
+    return nil
+}
+
+func (c *ResponseCache) do(ctx context.Context, service string, args interface{}, reply interface{}, call callFunc) error {
+    if c == nil {
+        return call(ctx, service, args, reply)
+    }
+    c.mutex.Lock()
+    ttl, ok := c.TTL[service]
+    c.mutex.Unlock()
+    if !ok {
+        return call(ctx, service, args, reply)
+    }
+    data, err := marshalSorted(args)
+    if err != nil {
+        return Errorf(CodeInvalidArgument, "%v", err)
+    }
+    key := coalesceKey(service, OutgoingMetadata(ctx), data)
+    cached, gen, ok := c.get(key, service)
+    if ok {
+        return Unmarshal(cached, reply)
+    }
+
+    var raw RawMessage
+    if err := call(ctx, service, RawMessage(data), &raw); err != nil {
+        return err
+    }
+    c.put(key, service, data, raw, ttl, gen)
+    return Unmarshal(raw, reply)
+}
+
+// get 未命中时返回服务当前的失效代数, 供 put 判断请求期间是否有失效通知
+func (c *ResponseCache) get(key string, service string) (RawMessage, uint64, bool) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    if el, ok := c.entries[key]; ok {
+        entry := el.Value.(*cacheEntry)
+        if time.Now().Before(entry.expires) {
+            c.lru.MoveToFront(el)
+            c.hits++
+            return entry.data, 0, true
+        }
+        c.removeElement(el)
+    }
+    c.misses++
+    return nil, c.gens[service], false
+}
+
+func (c *ResponseCache) put(key string, service string, args []byte, data RawMessage, ttl time.Duration, gen uint64) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    if c.gens[service] != gen {
+        // 请求期间收到了失效通知, 响应可能已经过时
+        return
+    }
+    if c.entries == nil {
+        c.entries = make(map[string]*list.Element)
+        c.lru = list.New()
+    }
+    if el, ok := c.entries[key]; ok {
+        c.removeElement(el)
+    }
+    c.entries[key] = c.lru.PushFront(&cacheEntry{
+        key:     key,
+        service: service,
+        args:    string(args),
+        data:    data,
+        expires: time.Now().Add(ttl),
+    })
+    for c.MaxEntries > 0 && c.lru.Len() > c.MaxEntries {
+        c.removeElement(c.lru.Back())
+    }
+}
+
+// remove 清除服务参数为 args 的所有条目, 失效通知不带元数据, 所以不区分元数据
+func (c *ResponseCache) remove(service string, args []byte) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    c.bump(service)
+    if c.lru == nil {
+        return
+    }
+    for el := c.lru.Front(); el != nil; {
+        next := el.Next()
+        if entry := el.Value.(*cacheEntry); entry.service == service && entry.args == string(args) {
+            c.removeElement(el)
+        }
+        el = next
+    }
+}
+
+func (c *ResponseCache) purge(service string) {
+    c.bump(service)
+    if c.lru == nil {
+        return
+    }
+    for el := c.lru.Front(); el != nil; {
+        next := el.Next()
+        if el.Value.(*cacheEntry).service == service {
+            c.removeElement(el)
+        }
+        el = next
+    }
+}
+
+// bump 使服务正在进行的请求的响应不再写入缓存
+func (c *ResponseCache) bump(service string) {
+    if c.gens == nil {
+        c.gens = make(map[string]uint64)
+    }
+    c.gens[service]++
+}
+
+func (c *ResponseCache) removeElement(el *list.Element) {
+    c.lru.Remove(el)
+    delete(c.entries, el.Value.(*cacheEntry).key)
+}
+
+func (o *CallOptions) handleInvalidate(ctx context.Context, req *invalidateRequest, _ *empty) error {
+    if o.Cache == nil {
+        return nil
+    }
+    if req.Args == nil {
+        return o.Cache.Invalidate(req.Service, nil)
+    }
+    o.Cache.remove(req.Service, req.Args)
+    return nil
+}
+
+// Invalidate 通知所有连接的客户端清除缓存, args 为 nil 时清除该服务的所有缓存
+func (s *Server) Invalidate(service string, args interface{}) error {
+    req := &invalidateRequest{Service: service}
+    if args != nil {
+        data, err := marshalSorted(args)
+        if err != nil {
+            return err
+        }
+        req.Args = data
+    }
+    return s.Broadcast(serviceInvalidate, req)
+}
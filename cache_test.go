@@ -0,0 +1,168 @@
+package rpc
+
+import (
+    "context"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestResponseCacheLRU(t *testing.T) {
+    c := NewResponseCache(2)
+    c.SetTTL("get", time.Minute)
+    for _, key := range []string{"a", "b", "a", "c"} {
+        if _, gen, ok := c.get(key, "get"); !ok {
+            c.put(key, "get", nil, RawMessage(key), time.Minute, gen)
+        }
+    }
+    // b 最久未使用, 被淘汰
+    tests := []struct {
+        key string
+        hit bool
+    }{
+        {"a", true},
+        {"b", false},
+        {"c", true},
+    }
+    for _, tt := range tests {
+        if _, _, ok := c.get(tt.key, "get"); ok != tt.hit {
+            t.Errorf("get(%s) hit = %v", tt.key, ok)
+        }
+    }
+    c.put("d", "get", nil, RawMessage("d"), -time.Second, c.gens["get"])
+    if _, _, ok := c.get("d", "get"); ok {
+        t.Error("expired entry hit")
+    }
+}
+
+func startCountingServer(t *testing.T, release chan struct{}) (*Server, string, *int32) {
+    var calls int32
+    s, addr := startServer(t, func(s *Server) {
+        s.Register("get", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+            n := atomic.AddInt32(&calls, 1)
+            if release != nil {
+                <-release
+            }
+            reply.N = int(n)
+            return nil
+        })
+    })
+    return s, addr, &calls
+}
+
+func TestResponseCacheCall(t *testing.T) {
+    s, addr, calls := startCountingServer(t, nil)
+    c := newTestClient(t, addr)
+    c.Cache = NewResponseCache(100)
+    c.Cache.SetTTL("get", time.Minute)
+
+    get := func(name string) int {
+        var reply echoArgs
+        if err := c.Call(context.Background(), "get", &echoArgs{Name: name}, &reply); err != nil {
+            t.Fatal(err)
+        }
+        return reply.N
+    }
+    if get("a") != 1 || get("a") != 1 || get("b") != 2 {
+        t.Fatal("unexpected cache result")
+    }
+    if st := c.Cache.Stats(); st.Hits != 1 || st.Misses != 2 || st.Entries != 2 {
+        t.Fatalf("stats = %+v", st)
+    }
+
+    // 服务端推送的失效通知
+    if err := s.Invalidate("get", &echoArgs{Name: "a"}); err != nil {
+        t.Fatal(err)
+    }
+    eventually(t, time.Second, func() bool { return c.Cache.Stats().Entries == 1 })
+    if get("a") != 3 || get("b") != 2 {
+        t.Fatal("invalidated key served from cache")
+    }
+    if err := s.Invalidate("get", nil); err != nil {
+        t.Fatal(err)
+    }
+    eventually(t, time.Second, func() bool { return c.Cache.Stats().Entries == 0 })
+    if atomic.LoadInt32(calls) != 3 {
+        t.Fatalf("calls = %d", atomic.LoadInt32(calls))
+    }
+}
+
+func TestResponseCacheInvalidateDuringMiss(t *testing.T) {
+    release := make(chan struct{})
+    _, addr, calls := startCountingServer(t, release)
+    c := newTestClient(t, addr)
+    c.Cache = NewResponseCache(100)
+    c.Cache.SetTTL("get", time.Minute)
+
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        if err := c.Call(context.Background(), "get", &echoArgs{}, &echoArgs{}); err != nil {
+            t.Error(err)
+        }
+    }()
+    eventually(t, time.Second, func() bool { return atomic.LoadInt32(calls) == 1 })
+    // 请求进行中收到的失效通知, 过时的响应不能写入缓存
+    if err := c.Cache.Invalidate("get", &echoArgs{}); err != nil {
+        t.Fatal(err)
+    }
+    close(release)
+    <-done
+    if st := c.Cache.Stats(); st.Entries != 0 {
+        t.Fatalf("stale response cached: %+v", st)
+    }
+    var reply echoArgs
+    if err := c.Call(context.Background(), "get", &echoArgs{}, &reply); err != nil || reply.N != 2 {
+        t.Fatalf("call after invalidate: %v %v", err, reply)
+    }
+}
+
+func TestResponseCacheKey(t *testing.T) {
+    _, addr, calls := startCountingServer(t, nil)
+    c := newTestClient(t, addr)
+    c.Cache = NewResponseCache(100)
+    c.Cache.SetTTL("get", time.Minute)
+
+    get := func(ctx context.Context, args interface{}) int {
+        var reply echoArgs
+        if err := c.Call(ctx, "get", args, &reply); err != nil {
+            t.Fatal(err)
+        }
+        return reply.N
+    }
+    // map 按键排序编码, 插入顺序不同的参数命中同一条缓存
+    ctx := context.Background()
+    for i := 0; i < 20; i++ {
+        args := map[string]interface{}{}
+        for j := 0; j < 8; j++ {
+            k := string(rune('a' + (i+j)%8))
+            args[k] = k
+        }
+        if n := get(ctx, args); n != 1 {
+            t.Fatalf("call %d: n = %d", i, n)
+        }
+    }
+
+    // 元数据不同的调用各自缓存
+    shard := AppendMetadata(ctx, MetadataShardKey, "1")
+    if get(ctx, &echoArgs{Name: "a"}) != 2 || get(shard, &echoArgs{Name: "a"}) != 3 {
+        t.Fatal("calls with different metadata shared a cache entry")
+    }
+    if get(ctx, &echoArgs{Name: "a"}) != 2 || get(shard, &echoArgs{Name: "a"}) != 3 {
+        t.Fatal("cache miss")
+    }
+
+    // 按参数失效时清除所有元数据的条目
+    if err := c.Cache.Invalidate("get", &echoArgs{Name: "a"}); err != nil {
+        t.Fatal(err)
+    }
+    if st := c.Cache.Stats(); st.Entries != 1 {
+        t.Fatalf("stats after invalidate = %+v", st)
+    }
+    if get(ctx, &echoArgs{Name: "a"}) != 4 || get(shard, &echoArgs{Name: "a"}) != 5 {
+        t.Fatal("invalidated entry served from cache")
+    }
+    if atomic.LoadInt32(calls) != 5 {
+        t.Fatalf("calls = %d", atomic.LoadInt32(calls))
+    }
+}
@@ -3,7 +3,10 @@ package rpc
 import (
     "context"
     "github.com/DGHeroin/rpc/pb"
+    "google.golang.org/protobuf/proto"
+    "strconv"
     "sync"
+    "time"
 )
 
 type (
@@ -14,6 +17,9 @@ type (
         Id   uint32
         done chan *pb.Message
         conn *Conn
+        err  error
+        sent int
+        recv int
     }
     CallManager struct {
         mutex  sync.Mutex
@@ -33,15 +39,105 @@ func (call *Call) Call(ctx context.Context, service string, args interface{}, re
     if err != nil {
         return err
     }
+    putMetadata(req, OutgoingMetadata(ctx))
+    if deadline, ok := ctx.Deadline(); ok {
+        timeout := time.Until(deadline)
+        if timeout <= 0 {
+            return unsentErrorf(CodeDeadlineExceeded, "%v", context.DeadlineExceeded)
+        }
+        dictSet(req, MetadataTimeout, []byte(strconv.FormatInt(int64(timeout/time.Millisecond)+1, 10)))
+    }
+    call.sent = len(req.Payload)
     err = call.conn.Send(1, req)
     if err != nil {
+        return connErrorf(true, "%v", err)
+    }
+    var msg *pb.Message
+    select {
+    case msg = <-call.done:
+    case <-ctx.Done():
+        // 通知对端取消处理, 之后到达的响应会被丢弃
+        _ = call.conn.Send(4, &pb.Message{Action: proto.Int32(4), Id: proto.Uint32(call.Id)})
+        return contextError(ctx.Err())
+    }
+    if msg == nil {
+        return call.err
+    }
+    call.recv = len(msg.Payload)
+    if err = replyError(msg); err != nil {
         return err
     }
-    msg := <-call.done
     err = Unmarshal(msg.Payload, reply)
     return err
 }
 
+func contextError(err error) error {
+    if err == context.DeadlineExceeded {
+        return Errorf(CodeDeadlineExceeded, "%v", err)
+    }
+    return Errorf(CodeCanceled, "%v", err)
+}
+
+// inflight 记录正在处理的请求, 收到取消帧时取消对应的 ctx
+type inflight struct {
+    mutex   sync.Mutex
+    cancels map[uint32]context.CancelFunc
+}
+
+func newInflight() *inflight {
+    return &inflight{
+        cancels: make(map[uint32]context.CancelFunc),
+    }
+}
+
+// start 返回请求的处理 ctx, 对端携带超时时附加 deadline
+func (f *inflight) start(ctx context.Context, msg *pb.Message) (context.Context, func()) {
+    var cancel context.CancelFunc
+    if val, ok := dictGet(msg.Dict, MetadataTimeout); ok {
+        if ms, err := strconv.ParseInt(string(val), 10, 64); err == nil && ms > 0 {
+            ctx, cancel = context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+        }
+    }
+    if cancel == nil {
+        ctx, cancel = context.WithCancel(ctx)
+    }
+    id := msg.GetId()
+    f.mutex.Lock()
+    f.cancels[id] = cancel
+    f.mutex.Unlock()
+    return ctx, func() {
+        f.mutex.Lock()
+        delete(f.cancels, id)
+        f.mutex.Unlock()
+        cancel()
+    }
+}
+
+func (f *inflight) cancel(id uint32) {
+    f.mutex.Lock()
+    cancel, ok := f.cancels[id]
+    f.mutex.Unlock()
+    if ok {
+        cancel()
+    }
+}
+
+func (f *inflight) len() int {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    return len(f.cancels)
+}
+
+func (f *inflight) cancelAll() {
+    f.mutex.Lock()
+    cancels := f.cancels
+    f.cancels = make(map[uint32]context.CancelFunc)
+    f.mutex.Unlock()
+    for _, cancel := range cancels {
+        cancel()
+    }
+}
+
 func asyncDo(fn func(), wg *sync.WaitGroup) {
     wg.Add(1)
     go func() {
@@ -54,7 +150,7 @@ func (m *CallManager) newCall(conn *Conn) *Call {
     call := &Call{
         Id:   m.nexId(),
         conn: conn,
-        done: make(chan *pb.Message),
+        done: make(chan *pb.Message, 1),
     }
     m.addCall(call)
     return call
@@ -69,6 +165,7 @@ func (m *CallManager) nexId() uint32 {
             m.reqId++
             break
         }
+        m.reqId++
     }
     return reqId
 }
@@ -85,5 +182,24 @@ func (m *CallManager) remCall(call *Call) {
 func (m *CallManager) popCall(id uint32) *Call {
     m.mutex.Lock()
     defer m.mutex.Unlock()
-    return m.reqMap[id]
+    call := m.reqMap[id]
+    delete(m.reqMap, id)
+    return call
+}
+
+// failConn 结束 conn 上所有未完成的调用
+func (m *CallManager) failConn(conn *Conn) {
+    m.mutex.Lock()
+    var calls []*Call
+    for id, call := range m.reqMap {
+        if call.conn == conn {
+            calls = append(calls, call)
+            delete(m.reqMap, id)
+        }
+    }
+    m.mutex.Unlock()
+    for _, call := range calls {
+        call.err = connErrorf(false, "connection closed")
+        call.done <- nil
+    }
 }
@@ -2,67 +2,161 @@ package rpc
 
 import (
     "context"
+    "crypto/tls"
     "github.com/DGHeroin/rpc/pb"
-    "log"
     "net"
     "sync"
+    "sync/atomic"
     "time"
 )
 
 type Client struct {
+    CallOptions
     ReadWriteTimeout time.Duration
+    PoolSize         int
+    PoolPolicy       PoolPolicy
+    TLSConfig        *tls.Config
     servant          *Servant
     addr             string
-    conn             *Conn
+    pool             *connPool
+    poolOnce         sync.Once
     mgr              *CallManager
+    subs             *subscriptions
+    health           *healthWatches
     waitGroup        sync.WaitGroup
 }
 
 func (client *Client) Call(ctx context.Context, service string, args interface{}, reply interface{}) error {
-    conn, err := client.GetConn()
+    return client.invoke(ctx, service, args, reply, client.call)
+}
+
+func (client *Client) call(ctx context.Context, service string, args interface{}, reply interface{}) error {
+    return client.Breaker.do(endpointBreakerName(client.addr), func() error {
+        return client.callOn(ctx, client.getPool().pick(client.PoolPolicy), service, args, reply)
+    })
+}
+
+func (client *Client) callOn(ctx context.Context, slot *pooledConn, service string, args interface{}, reply interface{}) error {
+    atomic.AddUint64(&slot.calls, 1)
+    atomic.AddInt64(&slot.pending, 1)
+    defer atomic.AddInt64(&slot.pending, -1)
+    conn, err := slot.get(client.dial)
     if err != nil {
-        return err
+        atomic.AddUint64(&slot.errors, 1)
+        return connErrorf(true, "%v", err)
     }
+
+    ctx, span := client.servant.tracing.startClient(ctx, service, client.addr)
+    finish := startCall(client.servant.metrics, SideClient, service)
     call := client.mgr.newCall(conn)
-    defer func() {
-        client.mgr.remCall(call)
-        close(call.done)
-    }()
+    defer client.mgr.remCall(call)
     err = call.Call(ctx, service, args, reply)
+    finish(err)
+    endCall(span, call, err)
+    if err != nil {
+        atomic.AddUint64(&slot.errors, 1)
+    }
     return err
 }
 
+func (client *Client) Notify(service string, args interface{}) error {
+    conn, err := client.GetConn()
+    if err != nil {
+        return err
+    }
+    msg, err := buildNotify(service, args)
+    if err != nil {
+        return err
+    }
+    return conn.Send(3, msg)
+}
+
 func (client *Client) GetConn() (*Conn, error) {
-    if client.conn != nil {
-        return client.conn, nil
+    return client.getPool().pick(client.PoolPolicy).get(client.dial)
+}
+
+// PoolStats 返回连接池中每个连接的统计
+func (client *Client) PoolStats() []PoolStats {
+    return client.getPool().stats()
+}
+
+func (client *Client) Close() {
+    client.getPool().closeAll()
+}
+
+func (client *Client) getPool() *connPool {
+    client.poolOnce.Do(func() {
+        client.pool = newConnPool(client.PoolSize)
+    })
+    return client.pool
+}
+
+// primary 返回固定的首个连接, 订阅等需要连接亲和的调用使用它
+func (client *Client) primary() *pooledConn {
+    return client.getPool().slots[0]
+}
+
+func (client *Client) dial(slot *pooledConn) (*Conn, error) {
+    var (
+        conn net.Conn
+        err  error
+    )
+    if client.TLSConfig != nil {
+        conn, err = tls.Dial("tcp", client.addr, client.TLSConfig)
+    } else {
+        conn, err = net.Dial("tcp", client.addr)
     }
-    conn, err := net.Dial("tcp", client.addr)
     if err != nil {
         return nil, err
     }
-    client.conn = NewConn(conn, &client.waitGroup)
-    client.conn.OnMessage = client.handleMessage
-    client.conn.Do()
-    client.conn.OnClose = func(conn *Conn) {
-        log.Println("关闭连接...")
-        client.conn = nil
+    c := NewConn(conn, &client.waitGroup)
+    c.instrument(client.servant.metrics, SideClient)
+    peer := newPeer(c, client)
+    c.OnMessage = func(msgType byte, msg *pb.Message) {
+        client.handleMessage(peer, msgType, msg)
     }
-    return client.conn, nil
+    c.OnClose = func(conn *Conn) {
+        client.servant.logger().Debug("connection closed", "conn_id", conn.ID(), "addr", client.addr)
+        slot.release(conn)
+        client.mgr.failConn(conn)
+        peer.requests.cancelAll()
+        peer.Session.Clear()
+    }
+    c.Do()
+    if slot.index == 0 && len(client.subs.topics()) > 0 {
+        go client.resubscribe()
+    }
+    if slot.index == 0 && len(client.health.services()) > 0 {
+        go client.rewatch(c)
+    }
+    return c, nil
 }
 
-func (client *Client) handleMessage(msgType byte, msg *pb.Message) {
+func (client *Client) handleMessage(peer *Peer, msgType byte, msg *pb.Message) {
     switch msgType {
     case 0: // 心跳
     case 1: // request
-        reply := client.servant.handleRequest(msg)
-        client.conn.Send(2, reply)
+        ctx, done := peer.requests.start(newPeerContext(context.Background(), peer), msg)
+        asyncDo(func() {
+            defer done()
+            reply := client.servant.handleRequest(ctx, msg)
+            _ = peer.conn.Send(2, reply)
+        }, &client.waitGroup)
     case 2: // response
         call := client.mgr.popCall(*msg.Id)
         if call == nil {
-            log.Println("call 不存在")
+            // 调用已超时或取消, 迟到的响应直接丢弃
+            client.servant.logger().Debug("response for unknown call", "conn_id", peer.ConnID, "request_id", msg.GetId())
             return
         }
         call.done <- msg
+    case 3: // notify
+        asyncDo(func() {
+            ctx := newPeerContext(context.Background(), peer)
+            client.servant.handleNotify(ctx, msg)
+        }, &client.waitGroup)
+    case 4: // cancel
+        peer.requests.cancel(msg.GetId())
     }
 
 }
@@ -70,3 +164,16 @@ func (client *Client) handleMessage(msgType byte, msg *pb.Message) {
 func (client *Client) Register(serviceName string, i interface{}) bool {
     return client.servant.Register(serviceName, i)
 }
+
+func (client *Client) OnPanic(fn func(service string, e interface{}, stack []byte)) {
+    client.servant.OnPanic(fn)
+}
+
+// SetPanicStackSize 设置 panic 调用栈的截断长度, size <= 0 时不截断
+func (client *Client) SetPanicStackSize(size int) {
+    client.servant.StackSize = size
+}
+
+func (client *Client) PanicCount() uint64 {
+    return client.servant.PanicCount()
+}
@@ -0,0 +1,172 @@
+// rpcdump 解码 BAB@ 协议的数据帧, 每帧输出一行 JSON
+//
+//	rpcdump [file|-]                         解码保存的字节流, 默认读标准输入
+//	rpcdump -listen :1601 -target host:1600  作为透明 TCP 代理, 记录两个方向的数据帧
+//
+// 代理模式下 -save dir 会把每个连接每个方向的原始字节流保存为文件, 之后可以再用 rpcdump 解码
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "github.com/DGHeroin/rpc"
+    "io"
+    "io/ioutil"
+    "log"
+    "net"
+    "os"
+    "path/filepath"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+var (
+    listen  = flag.String("listen", "", "proxy listen address")
+    target  = flag.String("target", "", "proxy target address")
+    save    = flag.String("save", "", "directory to save raw streams in proxy mode")
+    maxSize = flag.Int("max-size", rpc.DefaultMaxFrameSize, "largest frame payload to decode, 0 for no limit")
+)
+
+type record struct {
+    Time string `json:"time,omitempty"`
+    Conn uint64 `json:"conn,omitempty"`
+    Dir  string `json:"dir,omitempty"`
+    *rpc.FrameDump
+}
+
+var (
+    outMutex sync.Mutex
+    encoder  = json.NewEncoder(os.Stdout)
+    connSeq  uint64
+)
+
+func main() {
+    flag.Usage = func() {
+        fmt.Fprintf(os.Stderr, "usage: %s [file|-]\n       %s -listen addr -target addr [-save dir]\n", os.Args[0], os.Args[0])
+        flag.PrintDefaults()
+    }
+    flag.Parse()
+    if *listen != "" || *target != "" {
+        if *listen == "" || *target == "" {
+            flag.Usage()
+            os.Exit(2)
+        }
+        if err := proxy(); err != nil {
+            log.Fatal(err)
+        }
+        return
+    }
+
+    in := io.Reader(os.Stdin)
+    if name := flag.Arg(0); name != "" && name != "-" {
+        f, err := os.Open(name)
+        if err != nil {
+            log.Fatal(err)
+        }
+        defer f.Close()
+        in = f
+    }
+    if err := dump(in, 0, ""); err != nil {
+        log.Fatal(err)
+    }
+}
+
+// dump 逐帧输出, 流正常结束时返回 nil
+func dump(r io.Reader, conn uint64, dir string) error {
+    fr := rpc.NewFrameReader(r)
+    fr.MaxSize = *maxSize
+    for {
+        f, err := fr.Next()
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        rec := record{Conn: conn, Dir: dir, FrameDump: f.Dump()}
+        if conn != 0 {
+            rec.Time = time.Now().Format(time.RFC3339Nano)
+        }
+        outMutex.Lock()
+        _ = encoder.Encode(rec)
+        outMutex.Unlock()
+    }
+}
+
+func proxy() error {
+    if *save != "" {
+        if err := os.MkdirAll(*save, 0755); err != nil {
+            return err
+        }
+    }
+    ln, err := net.Listen("tcp", *listen)
+    if err != nil {
+        return err
+    }
+    log.Printf("proxy %s -> %s", ln.Addr(), *target)
+    for {
+        conn, err := ln.Accept()
+        if err != nil {
+            return err
+        }
+        go handle(conn)
+    }
+}
+
+func handle(client net.Conn) {
+    defer client.Close()
+    server, err := net.Dial("tcp", *target)
+    if err != nil {
+        log.Printf("dial %s: %v", *target, err)
+        return
+    }
+    defer server.Close()
+
+    id := atomic.AddUint64(&connSeq, 1)
+    log.Printf("conn %d: %s -> %s", id, client.RemoteAddr(), *target)
+    var wg sync.WaitGroup
+    wg.Add(2)
+    go func() {
+        defer wg.Done()
+        pipe(id, "c2s", client, server)
+        // 一个方向结束时关闭两端, 让另一个方向也退出
+        client.Close()
+        server.Close()
+    }()
+    go func() {
+        defer wg.Done()
+        pipe(id, "s2c", server, client)
+        client.Close()
+        server.Close()
+    }()
+    wg.Wait()
+    log.Printf("conn %d: closed", id)
+}
+
+// pipe 原样转发数据, 同时把数据交给解码器, 解码失败不影响转发
+func pipe(id uint64, dir string, src net.Conn, dst net.Conn) {
+    pr, pw := io.Pipe()
+    writers := []io.Writer{dst, pw}
+    if *save != "" {
+        name := filepath.Join(*save, fmt.Sprintf("conn-%d-%s.bin", id, dir))
+        if f, err := os.Create(name); err != nil {
+            log.Printf("save %s: %v", name, err)
+        } else {
+            defer f.Close()
+            writers = append(writers, f)
+        }
+    }
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        if err := dump(pr, id, dir); err != nil {
+            log.Printf("conn %d %s: %v", id, dir, err)
+        }
+        _, _ = io.Copy(ioutil.Discard, pr)
+    }()
+    _, _ = io.Copy(io.MultiWriter(writers...), src)
+    pw.Close()
+    <-done
+}
@@ -0,0 +1,236 @@
+// rpcreplay 将 Server.Recorder 录制的请求发送到另一个服务器, 比较响应的错误码和内容
+//
+//	rpcreplay [flags] -target host:1600 record.jsonl
+//
+// 不一致的请求每条输出一行 JSON, 存在不一致时以状态码 1 退出
+package main
+
+import (
+    "context"
+    "crypto/tls"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "github.com/DGHeroin/rpc"
+    "io"
+    "log"
+    "os"
+    "reflect"
+    "strings"
+    "sync"
+    "time"
+)
+
+var (
+    target      = flag.String("target", "", "server to replay against")
+    timeout     = flag.Duration("timeout", 10*time.Second, "per call timeout")
+    speed       = flag.Float64("speed", 0, "replay speed relative to the recording, 0 sends as fast as possible")
+    concurrency = flag.Int("concurrency", 1, "number of calls in flight")
+    services    = flag.String("services", "", "comma separated services to replay, empty for all")
+    notify      = flag.Bool("notify", false, "also replay notifications")
+    useTLS      = flag.Bool("tls", false, "connect with TLS")
+    insecure    = flag.Bool("insecure", false, "skip TLS certificate verification")
+    verbose     = flag.Bool("v", false, "print matching calls too")
+)
+
+// 这些元数据由调用方按本次调用重新生成
+var skipMetadata = map[string]bool{
+    rpc.MetadataTimeout: true,
+    rpc.MetadataAttempt: true,
+    "traceparent":       true,
+    "tracestate":        true,
+}
+
+type (
+    result struct {
+        Code  string          `json:"code"`
+        Error string          `json:"error,omitempty"`
+        Reply json.RawMessage `json:"reply,omitempty"`
+    }
+    diff struct {
+        Index    int     `json:"index"`
+        Service  string  `json:"service"`
+        Id       uint32  `json:"id"`
+        Match    bool    `json:"match"`
+        Expected *result `json:"expected"`
+        Got      *result `json:"got"`
+    }
+    job struct {
+        index int
+        call  *rpc.RecordedCall
+    }
+)
+
+func main() {
+    flag.Usage = func() {
+        fmt.Fprintf(os.Stderr, "usage: %s [flags] -target addr [record.jsonl|-]\n", os.Args[0])
+        flag.PrintDefaults()
+    }
+    flag.Parse()
+    if *target == "" {
+        flag.Usage()
+        os.Exit(2)
+    }
+    in := io.Reader(os.Stdin)
+    if name := flag.Arg(0); name != "" && name != "-" {
+        f, err := os.Open(name)
+        if err != nil {
+            log.Fatal(err)
+        }
+        defer f.Close()
+        in = f
+    }
+    wanted := make(map[string]bool)
+    for _, name := range strings.Split(*services, ",") {
+        if name = strings.TrimSpace(name); name != "" {
+            wanted[name] = true
+        }
+    }
+
+    client := rpc.NewP2PClient(*target)
+    client.SetLogger(rpc.NopLogger())
+    if *useTLS {
+        client.TLSConfig = &tls.Config{InsecureSkipVerify: *insecure}
+    }
+    if *concurrency > 1 {
+        client.PoolSize = *concurrency
+    }
+    defer client.Close()
+
+    var (
+        wg       sync.WaitGroup
+        outMutex sync.Mutex
+        total    int
+        failed   int
+        jobs     = make(chan job)
+        encoder  = json.NewEncoder(os.Stdout)
+    )
+    for i := 0; i < *concurrency || i == 0; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for j := range jobs {
+                d := replay(client, j)
+                outMutex.Lock()
+                total++
+                if !d.Match {
+                    failed++
+                }
+                if !d.Match || *verbose {
+                    _ = encoder.Encode(d)
+                }
+                outMutex.Unlock()
+            }
+        }()
+    }
+
+    reader := rpc.NewRecordReader(in)
+    var first time.Time
+    start := time.Now()
+    for index := 0; ; index++ {
+        call, err := reader.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            log.Fatalf("record %d: %v", index, err)
+        }
+        if len(wanted) > 0 && !wanted[call.Service] {
+            continue
+        }
+        if *speed > 0 {
+            // 按录制时的间隔发送
+            if first.IsZero() {
+                first = call.Time
+            }
+            offset := time.Duration(float64(call.Time.Sub(first)) / *speed)
+            time.Sleep(time.Until(start.Add(offset)))
+        }
+        if call.Notify {
+            if *notify {
+                _ = client.Notify(call.Service, rpc.RawMessage(call.Payload))
+            }
+            continue
+        }
+        jobs <- job{index: index, call: call}
+    }
+    close(jobs)
+    wg.Wait()
+
+    log.Printf("replayed %d calls, %d mismatched", total, failed)
+    if failed > 0 {
+        os.Exit(1)
+    }
+}
+
+func replay(client *rpc.Client, j job) *diff {
+    call := j.call
+    ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+    defer cancel()
+    ctx = rpc.WithMetadata(ctx, replayMetadata(call.Metadata))
+
+    var reply rpc.RawMessage
+    err := client.Call(ctx, call.Service, rpc.RawMessage(call.Payload), &reply)
+    return compare(j.index, call, reply, err)
+}
+
+// replayMetadata 去掉需要按本次调用重新生成的元数据
+func replayMetadata(recorded map[string]string) rpc.Metadata {
+    md := rpc.Metadata{}
+    for k, v := range recorded {
+        if !skipMetadata[k] {
+            md[k] = v
+        }
+    }
+    return md
+}
+
+// compare 比较录制的响应和重放得到的 reply 与 err, 错误码相同且成功时响应按值相等才算一致
+func compare(index int, call *rpc.RecordedCall, reply rpc.RawMessage, err error) *diff {
+    got := &result{Code: rpc.CodeOf(err).String()}
+    if err != nil {
+        if e, ok := err.(*rpc.Error); ok {
+            got.Error = e.Message
+        } else {
+            got.Error = err.Error()
+        }
+    } else {
+        got.Reply = toJSON(reply)
+    }
+    expected := &result{Code: call.Code.String(), Error: call.Error}
+    if call.Code == rpc.CodeOK {
+        expected.Reply = toJSON(call.Reply)
+    }
+    return &diff{
+        Index:    index,
+        Service:  call.Service,
+        Id:       call.Id,
+        Match:    expected.Code == got.Code && sameJSON(expected.Reply, got.Reply),
+        Expected: expected,
+        Got:      got,
+    }
+}
+
+func toJSON(raw []byte) json.RawMessage {
+    data, err := rpc.RawToJSON(raw)
+    if err != nil {
+        data, _ = json.Marshal(fmt.Sprintf("undecodable payload: %v", err))
+    }
+    return data
+}
+
+// sameJSON 按值比较, 忽略 map 的键顺序
+func sameJSON(a json.RawMessage, b json.RawMessage) bool {
+    var va, vb interface{}
+    if len(a) > 0 {
+        if err := json.Unmarshal(a, &va); err != nil {
+            return false
+        }
+    }
+    if len(b) > 0 {
+        if err := json.Unmarshal(b, &vb); err != nil {
+            return false
+        }
+    }
+    return reflect.DeepEqual(va, vb)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+    "errors"
+    "github.com/DGHeroin/rpc"
+    "testing"
+)
+
+func mustRaw(t *testing.T, json string) rpc.RawMessage {
+    t.Helper()
+    raw, err := rpc.JSONToRaw([]byte(json))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return raw
+}
+
+func TestCompare(t *testing.T) {
+    ok := &rpc.RecordedCall{Service: "get", Id: 3, Code: rpc.CodeOK, Reply: mustRaw(t, `{"a":1,"b":[1,2]}`)}
+    notFound := &rpc.RecordedCall{Service: "get", Code: rpc.CodeNotFound, Error: "no such key"}
+    tests := []struct {
+        name  string
+        call  *rpc.RecordedCall
+        reply rpc.RawMessage
+        err   error
+        match bool
+    }{
+        {"same reply", ok, mustRaw(t, `{"a":1,"b":[1,2]}`), nil, true},
+        // map 的键顺序不影响比较
+        {"reordered keys", ok, mustRaw(t, `{"b":[1,2],"a":1}`), nil, true},
+        {"different reply", ok, mustRaw(t, `{"a":2,"b":[1,2]}`), nil, false},
+        {"different code", ok, nil, rpc.Errorf(rpc.CodeInternal, "boom"), false},
+        {"same error code", notFound, nil, rpc.Errorf(rpc.CodeNotFound, "other message"), true},
+        {"error now succeeds", notFound, mustRaw(t, `{}`), nil, false},
+        {"different error code", notFound, nil, errors.New("reset"), false},
+    }
+    for _, tt := range tests {
+        d := compare(1, tt.call, tt.reply, tt.err)
+        if d.Match != tt.match {
+            t.Errorf("%s: match = %v, expected %s got %s", tt.name, d.Match, d.Expected.Reply, d.Got.Reply)
+        }
+        if d.Index != 1 || d.Service != tt.call.Service || d.Id != tt.call.Id {
+            t.Errorf("%s: diff = %+v", tt.name, d)
+        }
+    }
+
+    d := compare(0, notFound, nil, rpc.Errorf(rpc.CodeInternal, "boom"))
+    if d.Expected.Code != rpc.CodeNotFound.String() || d.Got.Code != rpc.CodeInternal.String() || d.Got.Error != "boom" {
+        t.Fatalf("diff = %+v %+v", d.Expected, d.Got)
+    }
+}
+
+func TestReplayMetadata(t *testing.T) {
+    md := replayMetadata(map[string]string{
+        rpc.MetadataTimeout:  "1000",
+        rpc.MetadataAttempt:  "2",
+        "traceparent":        "00-abc",
+        rpc.MetadataShardKey: "user-1",
+    })
+    if len(md) != 1 || md[rpc.MetadataShardKey] != "user-1" {
+        t.Fatalf("md = %v", md)
+    }
+}
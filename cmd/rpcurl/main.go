@@ -0,0 +1,173 @@
+// rpcurl 调用服务并以 JSON 输出响应
+//
+//	rpcurl [flags] addr service [json]
+//	rpcurl -list [flags] addr [service...]
+//
+// 请求 JSON 可以直接给出, 也可以用 @file 从文件读取, 用 - 从标准输入读取, 省略时为 {}
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "github.com/DGHeroin/rpc"
+    "io/ioutil"
+    "os"
+    "strings"
+    "time"
+)
+
+type metadataFlag rpc.Metadata
+
+func (m metadataFlag) String() string {
+    pairs := make([]string, 0, len(m))
+    for k, v := range m {
+        pairs = append(pairs, k+"="+v)
+    }
+    return strings.Join(pairs, ",")
+}
+
+func (m metadataFlag) Set(s string) error {
+    i := strings.IndexAny(s, "=:")
+    if i <= 0 {
+        return fmt.Errorf("metadata must be key=value: %q", s)
+    }
+    m[strings.TrimSpace(s[:i])] = strings.TrimSpace(s[i+1:])
+    return nil
+}
+
+var (
+    list       = flag.Bool("list", false, "list services and their schemas via rpc.reflection")
+    timeout    = flag.Duration("timeout", 10*time.Second, "call timeout")
+    useTLS     = flag.Bool("tls", false, "connect with TLS")
+    caFile     = flag.String("cacert", "", "CA certificate file for verifying the server")
+    certFile   = flag.String("cert", "", "client certificate file")
+    keyFile    = flag.String("key", "", "client key file")
+    serverName = flag.String("servername", "", "server name for TLS verification")
+    insecure   = flag.Bool("insecure", false, "skip TLS certificate verification")
+    compact    = flag.Bool("compact", false, "print compact JSON")
+    metadata   = metadataFlag{}
+)
+
+func main() {
+    flag.Var(metadata, "H", "request metadata as key=value, repeatable")
+    flag.Usage = func() {
+        fmt.Fprintf(os.Stderr, "usage: %s [flags] addr service [json|@file|-]\n       %s -list [flags] addr [service...]\n", os.Args[0], os.Args[0])
+        flag.PrintDefaults()
+    }
+    flag.Parse()
+    args := flag.Args()
+    if len(args) < 1 || (!*list && len(args) < 2) {
+        flag.Usage()
+        os.Exit(2)
+    }
+
+    client := rpc.NewP2PClient(args[0])
+    client.SetLogger(rpc.NopLogger())
+    if *useTLS {
+        config, err := tlsConfig()
+        if err != nil {
+            fatal(err)
+        }
+        client.TLSConfig = config
+    }
+    defer client.Close()
+
+    ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+    defer cancel()
+    if len(metadata) > 0 {
+        ctx = rpc.WithMetadata(ctx, rpc.Metadata(metadata))
+    }
+
+    if *list {
+        services, err := rpc.ListServices(ctx, client, args[1:]...)
+        if err != nil {
+            fatal(err)
+        }
+        data, _ := json.Marshal(services)
+        output(data)
+        return
+    }
+
+    body, err := readBody(args[2:])
+    if err != nil {
+        fatal(err)
+    }
+    req, err := rpc.JSONToRaw(body)
+    if err != nil {
+        fatal(fmt.Errorf("bad request json: %v", err))
+    }
+    var reply rpc.RawMessage
+    if err := client.Call(ctx, args[1], req, &reply); err != nil {
+        fatal(err)
+    }
+    data, err := rpc.RawToJSON(reply)
+    if err != nil {
+        fatal(fmt.Errorf("bad reply: %v", err))
+    }
+    output(data)
+}
+
+func readBody(args []string) ([]byte, error) {
+    if len(args) == 0 {
+        return []byte("{}"), nil
+    }
+    switch arg := args[0]; {
+    case arg == "-":
+        return ioutil.ReadAll(os.Stdin)
+    case strings.HasPrefix(arg, "@"):
+        return ioutil.ReadFile(arg[1:])
+    default:
+        return []byte(arg), nil
+    }
+}
+
+func tlsConfig() (*tls.Config, error) {
+    config := &tls.Config{
+        ServerName:         *serverName,
+        InsecureSkipVerify: *insecure,
+    }
+    if *caFile != "" {
+        pem, err := ioutil.ReadFile(*caFile)
+        if err != nil {
+            return nil, err
+        }
+        pool := x509.NewCertPool()
+        if !pool.AppendCertsFromPEM(pem) {
+            return nil, fmt.Errorf("no certificates in %s", *caFile)
+        }
+        config.RootCAs = pool
+    }
+    if *certFile != "" || *keyFile != "" {
+        cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+        if err != nil {
+            return nil, err
+        }
+        config.Certificates = []tls.Certificate{cert}
+    }
+    return config, nil
+}
+
+func output(data []byte) {
+    if !*compact {
+        var buf bytes.Buffer
+        if err := json.Indent(&buf, data, "", "  "); err == nil {
+            data = buf.Bytes()
+        }
+    }
+    os.Stdout.Write(data)
+    os.Stdout.Write([]byte("\n"))
+}
+
+func fatal(err error) {
+    if e, ok := err.(*rpc.Error); ok {
+        fmt.Fprintf(os.Stderr, "%s: %s\n", e.Code, e.Message)
+    } else {
+        fmt.Fprintln(os.Stderr, err)
+    }
+    os.Exit(1)
+}
@@ -0,0 +1,146 @@
+package rpc
+
+import (
+    "context"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+type (
+    // Coalescer 合并并发的相同调用, 服务名, 元数据和编码后的参数都相同的调用共享一次请求
+    // 只对 Services 中开启的服务生效, 请求不受单个调用的取消影响, 所有等待的调用都离开后才被取消
+    Coalescer struct {
+        Services  map[string]bool
+        mutex     sync.Mutex
+        flights   map[string]*flight
+        coalesced uint64
+    }
+    flight struct {
+        done    chan struct{}
+        cancel  context.CancelFunc
+        waiters int
+        reply   RawMessage
+        err     error
+    }
+)
+
+func NewCoalescer(services ...string) *Coalescer {
+    c := &Coalescer{Services: make(map[string]bool)}
+    for _, service := range services {
+        c.Services[service] = true
+    }
+    return c
+}
+
+// Coalesced 返回被合并而没有单独发出请求的调用数
+func (c *Coalescer) Coalesced() uint64 {
+    return atomic.LoadUint64(&c.coalesced)
+}
+
+func (c *Coalescer) do(ctx context.Context, service string, args interface{}, reply interface{}, call callFunc) error {
+    if c == nil || !c.Services[service] {
+        return call(ctx, service, args, reply)
+    }
+    data, err := marshalSorted(args)
+    if err != nil {
+        return Errorf(CodeInvalidArgument, "%v", err)
+    }
+    key := coalesceKey(service, OutgoingMetadata(ctx), data)
+
+    c.mutex.Lock()
+    if c.flights == nil {
+        c.flights = make(map[string]*flight)
+    }
+    f, ok := c.flights[key]
+    if ok {
+        atomic.AddUint64(&c.coalesced, 1)
+    } else {
+        flightCtx, cancel := context.WithCancel(detachedContext{ctx})
+        f = &flight{done: make(chan struct{}), cancel: cancel}
+        c.flights[key] = f
+        go c.run(flightCtx, key, f, service, RawMessage(data), call)
+    }
+    f.waiters++
+    c.mutex.Unlock()
+
+    select {
+    case <-f.done:
+        return f.result(reply)
+    case <-ctx.Done():
+        c.leave(key, f)
+        return contextError(ctx.Err())
+    }
+}
+
+func (c *Coalescer) run(ctx context.Context, key string, f *flight, service string, args RawMessage, call callFunc) {
+    f.err = call(ctx, service, args, &f.reply)
+    c.mutex.Lock()
+    if c.flights[key] == f {
+        delete(c.flights, key)
+    }
+    c.mutex.Unlock()
+    f.cancel()
+    close(f.done)
+}
+
+// leave 在等待的调用被取消时调用, 最后一个离开时取消请求, 之后的相同调用重新发起请求
+func (c *Coalescer) leave(key string, f *flight) {
+    c.mutex.Lock()
+    f.waiters--
+    last := f.waiters == 0
+    if last && c.flights[key] == f {
+        delete(c.flights, key)
+    }
+    c.mutex.Unlock()
+    if last {
+        f.cancel()
+    }
+}
+
+// coalesceKey 包含元数据, 分片键或优先级不同的调用不会被合并
+func coalesceKey(service string, md Metadata, data []byte) string {
+    keys := make([]string, 0, len(md))
+    for k := range md {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    var b strings.Builder
+    b.WriteString(service)
+    for _, k := range keys {
+        b.WriteByte(0)
+        b.WriteString(strconv.Quote(k))
+        b.WriteByte('=')
+        b.WriteString(strconv.Quote(md[k]))
+    }
+    b.WriteByte(0)
+    b.Write(data)
+    return b.String()
+}
+
+func (f *flight) result(reply interface{}) error {
+    if f.err != nil {
+        return f.err
+    }
+    return Unmarshal(f.reply, reply)
+}
+
+// detachedContext 保留 ctx 中的值, 但不随 ctx 取消
+type detachedContext struct {
+    context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+    return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+    return nil
+}
+
+func (detachedContext) Err() error {
+    return nil
+}
@@ -0,0 +1,139 @@
+package rpc
+
+import (
+    "context"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestCoalesceKey(t *testing.T) {
+    tests := []struct {
+        name string
+        a, b Metadata
+        same bool
+    }{
+        {"no metadata", nil, Metadata{}, true},
+        {"same metadata", Metadata{"shard-key": "1", "x": "y"}, Metadata{"x": "y", "shard-key": "1"}, true},
+        {"different shard key", Metadata{"shard-key": "1"}, Metadata{"shard-key": "2"}, false},
+        {"extra priority", Metadata{}, Metadata{MetadataPriority: "2"}, false},
+        {"ambiguous join", Metadata{"a": "b=c"}, Metadata{"a=b": "c"}, false},
+    }
+    for _, tt := range tests {
+        ka := coalesceKey("svc", tt.a, []byte("args"))
+        kb := coalesceKey("svc", tt.b, []byte("args"))
+        if (ka == kb) != tt.same {
+            t.Errorf("%s: same = %v, want %v", tt.name, ka == kb, tt.same)
+        }
+    }
+}
+
+// startGatedServer 的 slow 服务阻塞到 release 关闭, 返回处理次数和被取消次数
+func startGatedServer(t *testing.T, release chan struct{}) (string, *int32, *int32) {
+    var calls, cancelled int32
+    _, addr := startServer(t, func(s *Server) {
+        s.Register("slow", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+            atomic.AddInt32(&calls, 1)
+            select {
+            case <-release:
+            case <-ctx.Done():
+                atomic.AddInt32(&cancelled, 1)
+                return ctx.Err()
+            }
+            reply.N = args.N
+            return nil
+        })
+    })
+    return addr, &calls, &cancelled
+}
+
+func TestCoalesceCalls(t *testing.T) {
+    release := make(chan struct{})
+    addr, calls, _ := startGatedServer(t, release)
+    c := newTestClient(t, addr)
+    c.Coalesce = NewCoalescer("slow")
+
+    var wg sync.WaitGroup
+    call := func(ctx context.Context) {
+        defer wg.Done()
+        var reply echoArgs
+        if err := c.Call(ctx, "slow", &echoArgs{N: 5}, &reply); err != nil || reply.N != 5 {
+            t.Errorf("call: %v %v", err, reply)
+        }
+    }
+    for i := 0; i < 5; i++ {
+        wg.Add(1)
+        go call(context.Background())
+    }
+    // 分片键不同的调用单独发出
+    wg.Add(1)
+    go call(WithShardKey(context.Background(), "other"))
+
+    eventually(t, time.Second, func() bool { return c.Coalesce.Coalesced() == 4 && atomic.LoadInt32(calls) == 2 })
+    close(release)
+    wg.Wait()
+    if n := atomic.LoadInt32(calls); n != 2 {
+        t.Fatalf("handler ran %d times", n)
+    }
+}
+
+func TestCoalesceLeaderCancelled(t *testing.T) {
+    release := make(chan struct{})
+    addr, calls, cancelled := startGatedServer(t, release)
+    c := newTestClient(t, addr)
+    c.Coalesce = NewCoalescer("slow")
+
+    leaderCtx, cancelLeader := context.WithCancel(context.Background())
+    leader := make(chan error, 1)
+    go func() {
+        leader <- c.Call(leaderCtx, "slow", &echoArgs{N: 1}, &echoArgs{})
+    }()
+    eventually(t, time.Second, func() bool { return atomic.LoadInt32(calls) == 1 })
+    follower := make(chan error, 1)
+    go func() {
+        var reply echoArgs
+        err := c.Call(context.Background(), "slow", &echoArgs{N: 1}, &reply)
+        if err == nil && reply.N != 1 {
+            t.Errorf("reply = %v", reply)
+        }
+        follower <- err
+    }()
+    eventually(t, time.Second, func() bool { return c.Coalesce.Coalesced() == 1 })
+
+    // 发起请求的调用被取消不影响其它等待的调用
+    cancelLeader()
+    if err := <-leader; CodeOf(err) != CodeCanceled {
+        t.Fatalf("leader err = %v", err)
+    }
+    close(release)
+    if err := <-follower; err != nil {
+        t.Fatalf("follower err = %v", err)
+    }
+    if n := atomic.LoadInt32(cancelled); n != 0 {
+        t.Fatalf("request cancelled %d times", n)
+    }
+}
+
+func TestCoalesceAllWaitersLeave(t *testing.T) {
+    release := make(chan struct{})
+    defer close(release)
+    addr, calls, cancelled := startGatedServer(t, release)
+    c := newTestClient(t, addr)
+    c.Coalesce = NewCoalescer("slow")
+
+    ctx, cancel := context.WithCancel(context.Background())
+    var wg sync.WaitGroup
+    for i := 0; i < 3; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            _ = c.Call(ctx, "slow", &echoArgs{}, &echoArgs{})
+        }()
+    }
+    eventually(t, time.Second, func() bool { return c.Coalesce.Coalesced() == 2 && atomic.LoadInt32(calls) == 1 })
+    cancel()
+    wg.Wait()
+    // 最后一个等待的调用离开后请求被取消
+    eventually(t, time.Second, func() bool { return atomic.LoadInt32(cancelled) == 1 })
+}
@@ -1,13 +1,107 @@
 package rpc
 
 import (
+    "bytes"
+    "encoding/json"
+    "fmt"
     "github.com/vmihailenco/msgpack"
 )
 
+// RawMessage 是已编码的数据, 作为参数时原样发送, 作为返回值时保存未解码的数据
+type RawMessage []byte
+
 func Marshal(ptr interface{}) ([]byte, error) {
+    switch v := ptr.(type) {
+    case RawMessage:
+        return v, nil
+    case *RawMessage:
+        return *v, nil
+    }
     return msgpack.Marshal(ptr)
 }
 
+// marshalSorted 按键排序编码 map, 相同内容的参数总是得到相同的编码, 用作缓存和合并的键
+func marshalSorted(ptr interface{}) ([]byte, error) {
+    switch v := ptr.(type) {
+    case RawMessage:
+        return v, nil
+    case *RawMessage:
+        return *v, nil
+    }
+    var buf bytes.Buffer
+    if err := msgpack.NewEncoder(&buf).SortMapKeys(true).Encode(ptr); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
 func Unmarshal(data []byte, ptr interface{}) error {
+    if raw, ok := ptr.(*RawMessage); ok {
+        *raw = append((*raw)[:0], data...)
+        return nil
+    }
     return msgpack.Unmarshal(data, ptr)
 }
+
+// JSONToRaw 将 JSON 转为 msgpack 编码, 整数保持为整数
+func JSONToRaw(data []byte) (RawMessage, error) {
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.UseNumber()
+    var v interface{}
+    if err := dec.Decode(&v); err != nil {
+        return nil, err
+    }
+    return msgpack.Marshal(fromJSON(v))
+}
+
+// RawToJSON 将 msgpack 编码的数据转为 JSON, []byte 按 base64 输出
+func RawToJSON(raw []byte) ([]byte, error) {
+    if len(raw) == 0 {
+        return []byte("null"), nil
+    }
+    var v interface{}
+    if err := msgpack.Unmarshal(raw, &v); err != nil {
+        return nil, err
+    }
+    return json.Marshal(toJSON(v))
+}
+
+func fromJSON(v interface{}) interface{} {
+    switch v := v.(type) {
+    case json.Number:
+        if n, err := v.Int64(); err == nil {
+            return n
+        }
+        f, _ := v.Float64()
+        return f
+    case []interface{}:
+        for i := range v {
+            v[i] = fromJSON(v[i])
+        }
+    case map[string]interface{}:
+        for k := range v {
+            v[k] = fromJSON(v[k])
+        }
+    }
+    return v
+}
+
+func toJSON(v interface{}) interface{} {
+    switch v := v.(type) {
+    case []interface{}:
+        for i := range v {
+            v[i] = toJSON(v[i])
+        }
+    case map[string]interface{}:
+        for k := range v {
+            v[k] = toJSON(v[k])
+        }
+    case map[interface{}]interface{}:
+        m := make(map[string]interface{}, len(v))
+        for k, val := range v {
+            m[fmt.Sprint(k)] = toJSON(val)
+        }
+        return m
+    }
+    return v
+}
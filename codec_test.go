@@ -0,0 +1,151 @@
+package rpc
+
+import (
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "math/big"
+    "net"
+    "testing"
+    "time"
+)
+
+func TestJSONRawRoundTrip(t *testing.T) {
+    cases := []struct {
+        in   string
+        want string
+    }{
+        {`null`, `null`},
+        {`42`, `42`},
+        {`-7`, `-7`},
+        {`1.5`, `1.5`},
+        {`"hi"`, `"hi"`},
+        {`true`, `true`},
+        {`[1,"a",null]`, `[1,"a",null]`},
+        {`{"Name":"x","N":3}`, `{"N":3,"Name":"x"}`},
+        {`{"a":{"b":[1,2]}}`, `{"a":{"b":[1,2]}}`},
+    }
+    for _, tc := range cases {
+        raw, err := JSONToRaw([]byte(tc.in))
+        if err != nil {
+            t.Fatalf("JSONToRaw(%s): %v", tc.in, err)
+        }
+        out, err := RawToJSON(raw)
+        if err != nil {
+            t.Fatalf("RawToJSON(%s): %v", tc.in, err)
+        }
+        if string(out) != tc.want {
+            t.Errorf("round trip %s = %s, want %s", tc.in, out, tc.want)
+        }
+    }
+
+    if _, err := JSONToRaw([]byte(`{bad`)); err == nil {
+        t.Error("JSONToRaw accepted invalid JSON")
+    }
+    if out, _ := RawToJSON(nil); string(out) != "null" {
+        t.Errorf("RawToJSON(nil) = %s", out)
+    }
+}
+
+// JSON 转换后的整数可以直接解码到结构体
+func TestJSONToRawDecodesIntoStruct(t *testing.T) {
+    raw, err := JSONToRaw([]byte(`{"Name":"x","N":3}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var args echoArgs
+    if err := Unmarshal(raw, &args); err != nil {
+        t.Fatal(err)
+    }
+    if args.Name != "x" || args.N != 3 {
+        t.Fatalf("got %+v", args)
+    }
+}
+
+func TestCallRawMessage(t *testing.T) {
+    _, addr := startServer(t, nil)
+    c := newTestClient(t, addr)
+    raw, err := JSONToRaw([]byte(`{"Name":"raw","N":9}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var reply RawMessage
+    if err := c.Call(context.Background(), "echo", raw, &reply); err != nil {
+        t.Fatal(err)
+    }
+    out, err := RawToJSON(reply)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if string(out) != `{"N":9,"Name":"raw"}` {
+        t.Fatalf("reply = %s", out)
+    }
+}
+
+func TestClientTLS(t *testing.T) {
+    cert, pool := selfSignedCert(t)
+    ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    s := NewP2PServer()
+    s.SetLogger(NopLogger())
+    s.Register("echo", echo)
+    go s.Serve(ln)
+    t.Cleanup(func() {
+        ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+        defer cancel()
+        _ = s.Shutdown(ctx)
+    })
+
+    c := newTestClient(t, ln.Addr().String())
+    c.TLSConfig = &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"}
+    reply := &echoArgs{}
+    if err := c.Call(context.Background(), "echo", &echoArgs{Name: "tls"}, reply); err != nil {
+        t.Fatal(err)
+    }
+    if reply.Name != "tls" {
+        t.Fatalf("reply = %+v", reply)
+    }
+
+    // 不信任服务器证书时连接失败
+    untrusted := newTestClient(t, ln.Addr().String())
+    untrusted.TLSConfig = &tls.Config{ServerName: "127.0.0.1"}
+    if err := untrusted.Call(context.Background(), "echo", &echoArgs{}, &echoArgs{}); err == nil {
+        t.Fatal("call with untrusted certificate succeeded")
+    }
+}
+
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tmpl := &x509.Certificate{
+        SerialNumber:          big.NewInt(1),
+        Subject:               pkix.Name{CommonName: "rpc test"},
+        NotBefore:             time.Now().Add(-time.Hour),
+        NotAfter:              time.Now().Add(time.Hour),
+        IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+        KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+        ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+        IsCA:                  true,
+        BasicConstraintsValid: true,
+    }
+    der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    leaf, err := x509.ParseCertificate(der)
+    if err != nil {
+        t.Fatal(err)
+    }
+    pool := x509.NewCertPool()
+    pool.AddCert(leaf)
+    return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
@@ -5,6 +5,7 @@ import (
     "google.golang.org/protobuf/proto"
     "net"
     "sync"
+    "sync/atomic"
     "time"
 )
 
@@ -14,6 +15,7 @@ type (
         payload *pb.Message
     }
     Conn struct {
+        id                uint64
         conn              net.Conn
         wg                *sync.WaitGroup
         closeCh           chan struct{}
@@ -24,17 +26,32 @@ type (
         OnClose           func(conn *Conn)
         packetSendChan    chan *pb.Message
         packetReceiveChan chan *recvPacket
+        pushQueue         chan []byte
+        created           time.Time
+        counters          connCounters
+        metrics           Metrics
+        side              Side
     }
 )
 
+var connIdSeq uint64
+
+// pushQueueSize 是每个连接待推送通知的上限
+const pushQueueSize = 256
+
 func NewConn(conn net.Conn, wg *sync.WaitGroup) *Conn {
     call := &Conn{
-        conn: conn,
-        wg:   wg,
+        id:                atomic.AddUint64(&connIdSeq, 1),
+        conn:              conn,
+        wg:                wg,
         closeCh:           make(chan struct{}),
         ReadTimeout:       time.Second * 10,
         packetSendChan:    make(chan *pb.Message),
         packetReceiveChan: make(chan *recvPacket),
+        pushQueue:         make(chan []byte, pushQueueSize),
+        created:           time.Now(),
+        metrics:           nopMetrics{},
+        side:              SideClient,
     }
     return call
 }
@@ -42,6 +59,7 @@ func (c *Conn) Do() {
     asyncDo(c.handleLoop, c.wg)
     asyncDo(c.readLoop, c.wg)
     asyncDo(c.writeLoop, c.wg)
+    asyncDo(c.pushLoop, c.wg)
 }
 func (c *Conn) handleLoop() {
     defer func() {
@@ -53,9 +71,11 @@ func (c *Conn) handleLoop() {
         case <-c.closeCh:
             return
         case msg := <-c.packetReceiveChan:
+            if msg == nil {
+                return
+            }
             msgType := headerTypeCode(msg.header)
             c.onMessage(msgType, msg.payload)
-        default:
         }
     }
 }
@@ -76,11 +96,21 @@ func (c *Conn) readLoop() {
         }
         header, pkt, err := c.readPacket()
         if err != nil {
+            // 等 handleLoop 处理完已读到的消息再关闭, 对端发完回复立即断开时回复不会丢失
+            select {
+            case <-c.closeCh:
+            case c.packetReceiveChan <- nil:
+            }
             return
         }
-        c.packetReceiveChan <- &recvPacket{
+        c.countRead(HeaderSize + int(headerGetPayloadSize(&header)))
+        select {
+        case <-c.closeCh:
+            return
+        case c.packetReceiveChan <- &recvPacket{
             header:  header,
             payload: pkt,
+        }:
         }
     }
 }
@@ -101,10 +131,75 @@ func (c *Conn) writeLoop() {
     }
 }
 
+func (c *Conn) pushLoop() {
+    defer func() {
+        recover()
+        c.Close()
+    }()
+    for {
+        select {
+        case <-c.closeCh:
+            return
+        case bin := <-c.pushQueue:
+            if bin == nil {
+                return
+            }
+            if err := c.SendRaw(bin); err != nil {
+                return
+            }
+        }
+    }
+}
+
+// push 把数据包放入推送队列后立即返回, 队列满说明对端读得太慢, 关闭连接而不阻塞调用方
+func (c *Conn) push(bin []byte) bool {
+    select {
+    case c.pushQueue <- bin:
+        return true
+    case <-c.closeCh:
+        return false
+    default:
+    }
+    c.Close()
+    return false
+}
+
+// closeAfterPush 在已排队的推送写完后关闭连接
+func (c *Conn) closeAfterPush() {
+    select {
+    case c.pushQueue <- nil:
+    case <-c.closeCh:
+    default:
+        c.Close()
+    }
+}
+
+func (c *Conn) ID() uint64 {
+    return c.id
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+    return c.conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+    return c.conn.LocalAddr()
+}
+
+func (c *Conn) closed() bool {
+    select {
+    case <-c.closeCh:
+        return true
+    default:
+        return false
+    }
+}
+
 func (c *Conn) Close() {
     c.closeOnce.Do(func() {
         _ = c.conn.Close()
         close(c.closeCh)
+        c.metrics.ConnClosed(c.side)
         if c.OnClose != nil {
             c.OnClose(c)
         }
@@ -143,7 +238,7 @@ func (c *Conn) readPacket() ([HeaderSize]byte, *pb.Message, error) {
             return header, nil, err
         }
         return header, msg, nil
-    case 2:
+    case 2, 3, 4: // response / notify / cancel
         payloadSize := headerGetPayloadSize(&header)
         if payload, err = readPayload(conn, payloadSize, c.ReadTimeout); err != nil {
             return header, nil, err
@@ -163,14 +258,22 @@ func (c *Conn) readPacket() ([HeaderSize]byte, *pb.Message, error) {
 }
 
 func (c *Conn) Send(msgType byte, msg *pb.Message) error {
-    c.mutex.Lock()
-    defer c.mutex.Unlock()
-
     bin, err := makePkt(msgType, msg)
     if err != nil {
         return err
     }
-    _, err = c.conn.Write(bin)
+    return c.SendRaw(bin)
+}
+
+// SendRaw 发送 makePkt 编码好的完整数据包, 用于同一数据包写入多个连接
+func (c *Conn) SendRaw(bin []byte) error {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    _, err := c.conn.Write(bin)
+    if err == nil {
+        c.countWritten(len(bin))
+    }
     return err
 }
 
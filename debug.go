@@ -0,0 +1,116 @@
+package rpc
+
+import (
+    "html/template"
+    "net/http"
+    "sort"
+    "sync/atomic"
+    "time"
+)
+
+const (
+    DefaultDebugPath = "/debug/rpc"
+)
+
+type (
+    // ServiceInfo 是一个已注册服务的调用统计
+    ServiceInfo struct {
+        Name   string
+        Args   string
+        Reply  string
+        Calls  uint64
+        Errors uint64
+    }
+    // ConnInfo 是一个连接的状态, Pending 为正在处理的请求和等待响应的调用数之和
+    ConnInfo struct {
+        ID         uint64
+        RemoteAddr string
+        Identity   string
+        Age        time.Duration
+        Pending    int
+        Stats      ConnStats
+    }
+)
+
+var debugTemplate = template.Must(template.New("rpc debug").Parse(`<html>
+<body>
+<title>Services</title>
+<table border="1" cellpadding="5">
+<tr><th align="center">Service</th><th align="center">Args</th><th align="center">Reply</th><th align="center">Calls</th><th align="center">Errors</th></tr>
+{{range .Services}}<tr><td align="left">{{.Name}}</td><td align="left">{{.Args}}</td><td align="left">{{.Reply}}</td><td align="right">{{.Calls}}</td><td align="right">{{.Errors}}</td></tr>
+{{end}}</table>
+<br>
+<table border="1" cellpadding="5">
+<tr><th align="center">Conn</th><th align="center">Peer</th><th align="center">Identity</th><th align="center">Age</th><th align="center">Pending</th><th align="center">Bytes read</th><th align="center">Bytes written</th><th align="center">Frames read</th><th align="center">Frames written</th></tr>
+{{range .Conns}}<tr><td align="right">{{.ID}}</td><td align="left">{{.RemoteAddr}}</td><td align="left">{{.Identity}}</td><td align="right">{{.Age}}</td><td align="right">{{.Pending}}</td><td align="right">{{.Stats.BytesRead}}</td><td align="right">{{.Stats.BytesWritten}}</td><td align="right">{{.Stats.FramesRead}}</td><td align="right">{{.Stats.FramesWritten}}</td></tr>
+{{end}}</table>
+</body>
+</html>`))
+
+// Services 返回已注册的服务及其调用统计, 按名称排序
+func (s *Servant) Services() []ServiceInfo {
+    result := make([]ServiceInfo, 0, len(s.handler))
+    for name, sh := range s.handler {
+        result = append(result, ServiceInfo{
+            Name:   name,
+            Args:   sh.r.String(),
+            Reply:  sh.w.String(),
+            Calls:  atomic.LoadUint64(&sh.calls),
+            Errors: atomic.LoadUint64(&sh.errors),
+        })
+    }
+    sort.Slice(result, func(i, j int) bool {
+        return result[i].Name < result[j].Name
+    })
+    return result
+}
+
+func (s *Server) Services() []ServiceInfo {
+    return s.servant.Services()
+}
+
+// ConnInfos 返回所有连接的状态, 按连接 id 排序
+func (s *Server) ConnInfos() []ConnInfo {
+    s.connMutex.RLock()
+    conns := make([]*acceptClient, 0, len(s.conns))
+    for _, cli := range s.conns {
+        conns = append(conns, cli)
+    }
+    s.connMutex.RUnlock()
+
+    now := time.Now()
+    result := make([]ConnInfo, 0, len(conns))
+    for _, cli := range conns {
+        stats := cli.conn.Stats()
+        result = append(result, ConnInfo{
+            ID:         cli.peer.ConnID,
+            RemoteAddr: cli.peer.RemoteAddr.String(),
+            Identity:   cli.peer.Identity,
+            Age:        now.Sub(stats.Created).Truncate(time.Second),
+            Pending:    cli.peer.requests.len() + cli.mgr.Pending(),
+            Stats:      stats,
+        })
+    }
+    sort.Slice(result, func(i, j int) bool {
+        return result[i].ID < result[j].ID
+    })
+    return result
+}
+
+// DebugHandler 返回列出服务和连接的调试页面, 需要自行挂载, 例如
+//
+//	http.Handle(rpc.DefaultDebugPath, srv.DebugHandler())
+func (s *Server) DebugHandler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        data := struct {
+            Services []ServiceInfo
+            Conns    []ConnInfo
+        }{
+            Services: s.Services(),
+            Conns:    s.ConnInfos(),
+        }
+        if err := debugTemplate.Execute(w, data); err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+        }
+    })
+}
@@ -0,0 +1,64 @@
+package rpc
+
+import (
+    "context"
+    "io/ioutil"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+func TestDebugHandler(t *testing.T) {
+    s, addr := startServer(t, func(s *Server) {
+        s.Register("fail", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+            return Errorf(CodeInternal, "failed")
+        })
+    })
+    c := newTestClient(t, addr)
+    ctx := context.Background()
+    for i := 0; i < 3; i++ {
+        _ = c.Call(ctx, "echo", &echoArgs{}, &echoArgs{})
+    }
+    _ = c.Call(ctx, "fail", &echoArgs{}, &echoArgs{})
+
+    services := make(map[string]ServiceInfo)
+    for _, info := range s.Services() {
+        services[info.Name] = info
+    }
+    tests := []struct {
+        name   string
+        calls  uint64
+        errors uint64
+    }{
+        {"echo", 3, 0},
+        {"fail", 1, 1},
+    }
+    for _, tt := range tests {
+        info := services[tt.name]
+        if info.Calls != tt.calls || info.Errors != tt.errors {
+            t.Errorf("%s: calls %d errors %d", tt.name, info.Calls, info.Errors)
+        }
+    }
+    if info := services["echo"]; info.Args != "rpc.echoArgs" || info.Reply != "rpc.echoArgs" {
+        t.Errorf("echo types = %s %s", info.Args, info.Reply)
+    }
+
+    conn, err := c.GetConn()
+    if err != nil {
+        t.Fatal(err)
+    }
+    infos := s.ConnInfos()
+    if len(infos) != 1 || infos[0].RemoteAddr != conn.LocalAddr().String() {
+        t.Fatalf("conn infos = %+v", infos)
+    }
+
+    rec := httptest.NewRecorder()
+    s.DebugHandler().ServeHTTP(rec, httptest.NewRequest("GET", DefaultDebugPath, nil))
+    body, _ := ioutil.ReadAll(rec.Body)
+    for _, want := range []string{"echo", "fail", conn.LocalAddr().String(), strconv.FormatUint(infos[0].ID, 10)} {
+        if !strings.Contains(string(body), want) {
+            t.Errorf("debug page missing %q", want)
+        }
+    }
+}
@@ -0,0 +1,154 @@
+package rpc
+
+import (
+    "errors"
+    "fmt"
+    "github.com/DGHeroin/rpc/pb"
+    "google.golang.org/protobuf/proto"
+    "strconv"
+    "time"
+)
+
+type Code int32
+
+const (
+    CodeOK Code = iota
+    CodeCanceled
+    CodeUnknown
+    CodeInvalidArgument
+    CodeDeadlineExceeded
+    CodeNotFound
+    CodeAlreadyExists
+    CodePermissionDenied
+    CodeResourceExhausted
+    CodeFailedPrecondition
+    CodeAborted
+    CodeOutOfRange
+    CodeUnimplemented
+    CodeInternal
+    CodeUnavailable
+    CodeDataLoss
+    CodeUnauthenticated
+)
+
+const (
+    dictKeyCode = "rpc-code"
+)
+
+var codeNames = map[Code]string{
+    CodeOK:                 "OK",
+    CodeCanceled:           "Canceled",
+    CodeUnknown:            "Unknown",
+    CodeInvalidArgument:    "InvalidArgument",
+    CodeDeadlineExceeded:   "DeadlineExceeded",
+    CodeNotFound:           "NotFound",
+    CodeAlreadyExists:      "AlreadyExists",
+    CodePermissionDenied:   "PermissionDenied",
+    CodeResourceExhausted:  "ResourceExhausted",
+    CodeFailedPrecondition: "FailedPrecondition",
+    CodeAborted:            "Aborted",
+    CodeOutOfRange:         "OutOfRange",
+    CodeUnimplemented:      "Unimplemented",
+    CodeInternal:           "Internal",
+    CodeUnavailable:        "Unavailable",
+    CodeDataLoss:           "DataLoss",
+    CodeUnauthenticated:    "Unauthenticated",
+}
+
+func (c Code) String() string {
+    if name, ok := codeNames[c]; ok {
+        return name
+    }
+    return "Code(" + strconv.Itoa(int(c)) + ")"
+}
+
+// Error 是携带错误码的远程调用错误
+type Error struct {
+    Code       Code
+    Message    string
+    unsent     bool
+    broken     bool
+    retryAfter time.Duration
+}
+
+func Errorf(code Code, format string, args ...interface{}) *Error {
+    return &Error{
+        Code:    code,
+        Message: fmt.Sprintf(format, args...),
+    }
+}
+
+// unsentErrorf 返回请求尚未写出连接时的错误, 这类错误总是可以安全重试
+func unsentErrorf(code Code, format string, args ...interface{}) *Error {
+    e := Errorf(code, format, args...)
+    e.unsent = true
+    return e
+}
+
+func isUnsent(err error) bool {
+    var e *Error
+    return errors.As(err, &e) && e.unsent
+}
+
+// connErrorf 返回连接建立或读写失败时的错误
+func connErrorf(unsent bool, format string, args ...interface{}) *Error {
+    e := Errorf(CodeUnavailable, format, args...)
+    e.unsent = unsent
+    e.broken = true
+    return e
+}
+
+// isConnFailure 判断错误是否由连接失败引起, 而不是对端返回的错误
+func isConnFailure(err error) bool {
+    var e *Error
+    return errors.As(err, &e) && e.broken
+}
+
+func (e *Error) Error() string {
+    return fmt.Sprintf("rpc error: code = %s desc = %s", e.Code, e.Message)
+}
+
+// CodeOf 返回 err 的错误码, 非 *Error 的错误视为 CodeUnknown
+func CodeOf(err error) Code {
+    if err == nil {
+        return CodeOK
+    }
+    var e *Error
+    if errors.As(err, &e) {
+        return e.Code
+    }
+    return CodeUnknown
+}
+
+func toError(err error) *Error {
+    var e *Error
+    if errors.As(err, &e) {
+        return e
+    }
+    return &Error{Code: CodeUnknown, Message: err.Error()}
+}
+
+func setReplyError(reply *pb.Message, err error) {
+    e := toError(err)
+    reply.Error = proto.String(e.Message)
+    dictSet(reply, dictKeyCode, []byte(strconv.Itoa(int(e.Code))))
+}
+
+func replyError(reply *pb.Message) error {
+    if reply.Error == nil {
+        return nil
+    }
+    code := CodeUnknown
+    if val, ok := dictGet(reply.Dict, dictKeyCode); ok {
+        if n, err := strconv.Atoi(string(val)); err == nil {
+            code = Code(n)
+        }
+    }
+    e := &Error{Code: code, Message: *reply.Error}
+    if val, ok := dictGet(reply.Dict, MetadataRetryAfter); ok {
+        if ms, err := strconv.ParseInt(string(val), 10, 64); err == nil {
+            e.retryAfter = time.Duration(ms) * time.Millisecond
+        }
+    }
+    return e
+}
@@ -0,0 +1,146 @@
+package rpc
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/DGHeroin/rpc/pb"
+    "google.golang.org/protobuf/proto"
+    "io"
+)
+
+// DefaultMaxFrameSize 是 FrameReader 默认接受的最大负载, 损坏的帧头不会导致按任意长度分配内存
+const DefaultMaxFrameSize = 64 << 20
+
+var frameTypeNames = map[byte]string{
+    0: "heartbeat",
+    1: "request",
+    2: "response",
+    3: "notify",
+    4: "cancel",
+}
+
+type (
+    // Frame 是从字节流中解码的一个数据帧, CRC 不符时仍尝试解码消息
+    Frame struct {
+        Type      byte
+        Size      int
+        CRC       uint32
+        CRCValid  bool
+        Body      []byte
+        Message   *pb.Message
+        DecodeErr error
+    }
+    // FrameReader 从保存的字节流或代理的连接中逐帧读取, 用于排查协议问题
+    // 负载超过 MaxSize 时 Next 返回 ErrPayloadSize, MaxSize <= 0 时不限制
+    FrameReader struct {
+        MaxSize int
+        r       io.Reader
+    }
+    // FrameDump 是 Frame 的 JSON 表示, msgpack 编码的 Payload 转为 JSON
+    FrameDump struct {
+        Type     string            `json:"type"`
+        Size     int               `json:"size"`
+        CRC      uint32            `json:"crc"`
+        CRCValid bool              `json:"crc_valid"`
+        Id       *uint32           `json:"id,omitempty"`
+        Action   int32             `json:"action,omitempty"`
+        Service  string            `json:"service,omitempty"`
+        Metadata map[string]string `json:"metadata,omitempty"`
+        Error    string            `json:"error,omitempty"`
+        Payload  json.RawMessage   `json:"payload,omitempty"`
+        RawSize  int               `json:"payload_size,omitempty"`
+        Problem  string            `json:"problem,omitempty"`
+    }
+)
+
+func NewFrameReader(r io.Reader) *FrameReader {
+    return &FrameReader{MaxSize: DefaultMaxFrameSize, r: r}
+}
+
+// Next 读取下一帧, 流结束时返回 io.EOF, 魔数错误时返回 ErrMagicCode, 负载过大时返回 ErrPayloadSize
+func (fr *FrameReader) Next() (*Frame, error) {
+    var header [HeaderSize]byte
+    if _, err := io.ReadFull(fr.r, header[:]); err != nil {
+        return nil, err
+    }
+    if !headerValidMagic(header) {
+        return nil, ErrMagicCode
+    }
+    f := &Frame{
+        Type: headerTypeCode(header),
+        Size: headerGetPayloadSize(&header),
+        CRC:  headerCrc(header),
+    }
+    if fr.MaxSize > 0 && f.Size > fr.MaxSize {
+        return nil, fmt.Errorf("%w: %d bytes exceeds %d", ErrPayloadSize, f.Size, fr.MaxSize)
+    }
+    if f.Size > 0 {
+        f.Body = make([]byte, f.Size)
+        if _, err := io.ReadFull(fr.r, f.Body); err != nil {
+            return nil, err
+        }
+    }
+    if num, _ := CalcCrc(f.Body); num == f.CRC || (len(f.Body) == 0 && f.CRC == 0) {
+        f.CRCValid = true
+    }
+    if len(f.Body) > 0 {
+        msg := &pb.Message{}
+        if err := proto.Unmarshal(f.Body, msg); err != nil {
+            f.DecodeErr = err
+        } else {
+            f.Message = msg
+        }
+    }
+    return f, nil
+}
+
+func (f *Frame) TypeName() string {
+    if name, ok := frameTypeNames[f.Type]; ok {
+        return name
+    }
+    return "unknown"
+}
+
+func (f *Frame) Dump() *FrameDump {
+    d := &FrameDump{
+        Type:     f.TypeName(),
+        Size:     f.Size,
+        CRC:      f.CRC,
+        CRCValid: f.CRCValid,
+    }
+    var problems []string
+    if !f.CRCValid {
+        problems = append(problems, ErrHeaderCRC.Error())
+    }
+    if f.DecodeErr != nil {
+        problems = append(problems, "bad message: "+f.DecodeErr.Error())
+    }
+    if msg := f.Message; msg != nil {
+        id := msg.GetId()
+        d.Id = &id
+        d.Action = msg.GetAction()
+        d.Service = msg.GetName()
+        d.Error = msg.GetError()
+        if dict := msg.GetDict(); dict != nil && len(dict.Values) > 0 {
+            d.Metadata = make(map[string]string, len(dict.Values))
+            for _, kv := range dict.Values {
+                d.Metadata[kv.GetKey()] = string(kv.Value)
+            }
+        }
+        d.RawSize = len(msg.Payload)
+        if len(msg.Payload) > 0 {
+            if data, err := RawToJSON(msg.Payload); err != nil {
+                problems = append(problems, "bad payload: "+err.Error())
+            } else {
+                d.Payload = data
+            }
+        }
+    }
+    for i, p := range problems {
+        if i > 0 {
+            d.Problem += "; "
+        }
+        d.Problem += p
+    }
+    return d
+}
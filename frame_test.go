@@ -0,0 +1,128 @@
+package rpc
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "io"
+    "testing"
+)
+
+func testPacket(t *testing.T, typeCode byte) []byte {
+    t.Helper()
+    msg, err := buildNotify("echo", &echoArgs{Name: "x", N: 1})
+    if err != nil {
+        t.Fatal(err)
+    }
+    bin, err := makePkt(typeCode, msg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return bin
+}
+
+func TestFrameReaderNext(t *testing.T) {
+    good := testPacket(t, 3)
+    badCRC := append([]byte(nil), good...)
+    badCRC[HeaderSize+len(badCRC[HeaderSize:])/2] ^= 0xff
+    badMagic := append([]byte(nil), good...)
+    badMagic[0] = 'X'
+    // 帧头声明的负载长度远大于实际数据
+    huge := append([]byte(nil), good[:HeaderSize]...)
+    binary.BigEndian.PutUint32(huge[4:8], 0xffffffff)
+    heartbeat, err := makePkt(0, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    cases := []struct {
+        name     string
+        data     []byte
+        err      error
+        crcValid bool
+        decoded  bool
+    }{
+        {"valid", good, nil, true, true},
+        {"bad crc", badCRC, nil, false, true},
+        {"heartbeat", heartbeat, nil, true, false},
+        {"bad magic", badMagic, ErrMagicCode, false, false},
+        {"truncated body", good[:len(good)-1], io.ErrUnexpectedEOF, false, false},
+        {"truncated header", good[:HeaderSize-1], io.ErrUnexpectedEOF, false, false},
+        {"oversized", huge, ErrPayloadSize, false, false},
+        {"empty", nil, io.EOF, false, false},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            f, err := NewFrameReader(bytes.NewReader(tc.data)).Next()
+            if !errors.Is(err, tc.err) {
+                t.Fatalf("err = %v, want %v", err, tc.err)
+            }
+            if err != nil {
+                return
+            }
+            if f.CRCValid != tc.crcValid {
+                t.Errorf("CRCValid = %v, want %v", f.CRCValid, tc.crcValid)
+            }
+            if (f.Message != nil && f.Message.GetName() == "echo") != tc.decoded {
+                t.Errorf("Message = %v, DecodeErr = %v", f.Message, f.DecodeErr)
+            }
+        })
+    }
+}
+
+func TestFrameReaderStream(t *testing.T) {
+    var buf bytes.Buffer
+    buf.Write(testPacket(t, 1))
+    buf.Write(testPacket(t, 2))
+    buf.Write(testPacket(t, 4))
+    fr := NewFrameReader(&buf)
+    var names []string
+    for {
+        f, err := fr.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+        names = append(names, f.TypeName())
+    }
+    if len(names) != 3 || names[0] != "request" || names[1] != "response" || names[2] != "cancel" {
+        t.Fatalf("frames = %v", names)
+    }
+}
+
+func TestFrameDump(t *testing.T) {
+    f, err := NewFrameReader(bytes.NewReader(testPacket(t, 3))).Next()
+    if err != nil {
+        t.Fatal(err)
+    }
+    d := f.Dump()
+    if d.Type != "notify" || d.Service != "echo" || !d.CRCValid || d.Problem != "" {
+        t.Fatalf("dump = %+v", d)
+    }
+    if string(d.Payload) != `{"N":1,"Name":"x"}` {
+        t.Fatalf("payload = %s", d.Payload)
+    }
+
+    f.CRCValid = false
+    f.Type = 9
+    d = f.Dump()
+    if d.Type != "unknown" || d.Problem != ErrHeaderCRC.Error() {
+        t.Fatalf("dump = %+v", d)
+    }
+}
+
+func TestFrameReaderMaxSize(t *testing.T) {
+    data := testPacket(t, 3)
+    fr := NewFrameReader(bytes.NewReader(data))
+    fr.MaxSize = len(data) - HeaderSize - 1
+    if _, err := fr.Next(); !errors.Is(err, ErrPayloadSize) {
+        t.Fatalf("err = %v, want ErrPayloadSize", err)
+    }
+    fr = NewFrameReader(bytes.NewReader(data))
+    fr.MaxSize = 0
+    if _, err := fr.Next(); err != nil {
+        t.Fatalf("unlimited reader: %v", err)
+    }
+}
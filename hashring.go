@@ -0,0 +1,143 @@
+package rpc
+
+import (
+    "context"
+    "hash/fnv"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+const (
+    MetadataShardKey = "shard-key"
+)
+
+// ShardKeyer 由请求参数实现, 返回用于一致性哈希路由的分片键
+type ShardKeyer interface {
+    ShardKey() string
+}
+
+func WithShardKey(ctx context.Context, key string) context.Context {
+    return AppendMetadata(ctx, MetadataShardKey, key)
+}
+
+// ShardKeyOf 依次从 ctx 元数据和请求参数中取分片键
+func ShardKeyOf(info PickInfo) (string, bool) {
+    if info.Ctx != nil {
+        if key, ok := OutgoingMetadata(info.Ctx)[MetadataShardKey]; ok {
+            return key, true
+        }
+    }
+    if keyer, ok := info.Args.(ShardKeyer); ok {
+        return keyer.ShardKey(), true
+    }
+    return "", false
+}
+
+type (
+    // HashRing 是带虚拟节点的一致性哈希负载均衡
+    // 哈希环由全部后端构成, 只有后端加入或离开时相邻区间的分片键才会改变归属,
+    // 归属的后端被摘除或熔断时调用失败而不是改道到其它后端
+    HashRing struct {
+        Replicas int
+        Fallback Balancer
+        mutex    sync.Mutex
+        sig      string
+        nodes    []ringNode
+    }
+    ringNode struct {
+        hash    uint32
+        backend *Backend
+    }
+)
+
+// ConsistentHash 返回按分片键路由的 Balancer, 每个权重单位有 replicas 个虚拟节点
+func ConsistentHash(replicas int) *HashRing {
+    if replicas <= 0 {
+        replicas = 100
+    }
+    return &HashRing{
+        Replicas: replicas,
+        Fallback: RoundRobin(),
+    }
+}
+
+func (r *HashRing) Pick(info PickInfo, backends []*Backend) *Backend {
+    key, ok := ShardKeyOf(info)
+    if !ok {
+        return r.Fallback.Pick(info, backends)
+    }
+    all := info.Backends
+    if len(all) == 0 {
+        all = backends
+    }
+    r.mutex.Lock()
+    r.rebuild(all)
+    owner := r.lookup(key)
+    r.mutex.Unlock()
+    for _, b := range backends {
+        if b == owner {
+            return owner
+        }
+    }
+    return nil
+}
+
+func (r *HashRing) lookup(key string) *Backend {
+    if len(r.nodes) == 0 {
+        return nil
+    }
+    h := ringHash(key)
+    i := sort.Search(len(r.nodes), func(i int) bool {
+        return r.nodes[i].hash >= h
+    })
+    if i == len(r.nodes) {
+        i = 0
+    }
+    return r.nodes[i].backend
+}
+
+// rebuild 在后端集合变化时重建哈希环
+func (r *HashRing) rebuild(backends []*Backend) {
+    parts := make([]string, 0, len(backends))
+    for _, b := range backends {
+        parts = append(parts, b.Addr+"/"+strconv.Itoa(b.weight()))
+    }
+    sort.Strings(parts)
+    sig := strings.Join(parts, ",")
+    if sig == r.sig {
+        return
+    }
+    nodes := make([]ringNode, 0, len(backends)*r.Replicas)
+    for _, b := range backends {
+        n := r.Replicas * b.weight()
+        for i := 0; i < n; i++ {
+            nodes = append(nodes, ringNode{
+                hash:    ringHash(b.Addr + "#" + strconv.Itoa(i)),
+                backend: b,
+            })
+        }
+    }
+    sort.Slice(nodes, func(i, j int) bool {
+        if nodes[i].hash == nodes[j].hash {
+            return nodes[i].backend.Addr < nodes[j].backend.Addr
+        }
+        return nodes[i].hash < nodes[j].hash
+    })
+    r.sig = sig
+    r.nodes = nodes
+}
+
+// ringHash 是 fnv-1a 加上 murmur3 的末尾混淆, 使相近的键均匀分布
+func ringHash(s string) uint32 {
+    h := fnv.New32a()
+    _, _ = h.Write([]byte(s))
+    x := h.Sum32()
+    x ^= x >> 16
+    x *= 0x85ebca6b
+    x ^= x >> 13
+    x *= 0xc2b2ae35
+    x ^= x >> 16
+    return x
+}
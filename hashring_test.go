@@ -0,0 +1,119 @@
+package rpc
+
+import (
+    "context"
+    "strconv"
+    "testing"
+)
+
+func ringBackends(addrs ...string) []*Backend {
+    backends := make([]*Backend, len(addrs))
+    for i, addr := range addrs {
+        backends[i] = &Backend{Endpoint: Endpoint{Addr: addr, Weight: 1}}
+    }
+    return backends
+}
+
+func shardOwners(r *HashRing, backends []*Backend, keys int) map[string]string {
+    owners := make(map[string]string, keys)
+    for i := 0; i < keys; i++ {
+        key := "player-" + strconv.Itoa(i)
+        owners[key] = r.Pick(PickInfo{Ctx: WithShardKey(context.Background(), key)}, backends).Addr
+    }
+    return owners
+}
+
+func TestRingHashDistribution(t *testing.T) {
+    tests := []struct {
+        backends int
+        replicas int
+    }{
+        {2, 100},
+        {3, 100},
+        {5, 160},
+    }
+    const keys = 10000
+    for _, tt := range tests {
+        addrs := make([]string, tt.backends)
+        for i := range addrs {
+            addrs[i] = "10.0.0." + strconv.Itoa(i+1) + ":1600"
+        }
+        counts := make(map[string]int)
+        for _, addr := range shardOwners(ConsistentHash(tt.replicas), ringBackends(addrs...), keys) {
+            counts[addr]++
+        }
+        expected := keys / tt.backends
+        for _, addr := range addrs {
+            if n := counts[addr]; n < expected*7/10 || n > expected*13/10 {
+                t.Errorf("%d backends: %s owns %d keys, expected about %d", tt.backends, addr, n, expected)
+            }
+        }
+    }
+}
+
+func TestRingMinimalMovement(t *testing.T) {
+    tests := []struct {
+        name   string
+        before []string
+        after  []string
+    }{
+        {"add", []string{"a:1", "b:1", "c:1"}, []string{"a:1", "b:1", "c:1", "d:1"}},
+        {"remove", []string{"a:1", "b:1", "c:1", "d:1"}, []string{"a:1", "b:1", "c:1"}},
+    }
+    const keys = 5000
+    for _, tt := range tests {
+        r := ConsistentHash(100)
+        before := shardOwners(r, ringBackends(tt.before...), keys)
+        after := shardOwners(r, ringBackends(tt.after...), keys)
+        moved := 0
+        for key, addr := range before {
+            if after[key] != addr {
+                moved++
+                // 只有离开的后端的键或移到新后端的键会改变归属
+                if addr != "d:1" && after[key] != "d:1" {
+                    t.Fatalf("%s: %s moved from %s to %s", tt.name, key, addr, after[key])
+                }
+            }
+        }
+        if moved > keys*2/5 {
+            t.Errorf("%s: %d of %d keys moved", tt.name, moved, keys)
+        }
+    }
+}
+
+func TestHashRingRouting(t *testing.T) {
+    addrs := startNamedServers(t, 2)
+    dead := closedAddr(t)
+    c := newTestBalancedClient(t, append(addrs, dead)...)
+    ring := ConsistentHash(100)
+    c.Balancer = ring
+
+    // 每个分片键总是落在同一个后端
+    served := make(map[string]string)
+    for i := 0; i < 50; i++ {
+        key := "player-" + strconv.Itoa(i)
+        ctx := WithShardKey(context.Background(), key)
+        for j := 0; j < 2; j++ {
+            var reply echoArgs
+            err := c.Call(ctx, "who", &echoArgs{}, &reply)
+            owner := ring.Pick(PickInfo{Ctx: ctx, Backends: c.Backends()}, c.Backends())
+            if owner.Addr == dead {
+                // 归属的后端不可用时不改道
+                if CodeOf(err) != CodeUnavailable {
+                    t.Fatalf("%s: err = %v, want Unavailable", key, err)
+                }
+                continue
+            }
+            if err != nil {
+                t.Fatalf("%s: %v", key, err)
+            }
+            if prev, ok := served[key]; ok && prev != reply.Name {
+                t.Fatalf("%s served by %s and %s", key, prev, reply.Name)
+            }
+            served[key] = reply.Name
+        }
+    }
+    if len(served) == 50 || len(served) == 0 {
+        t.Fatalf("%d of 50 keys served, expected the dead backend to own some", len(served))
+    }
+}
@@ -0,0 +1,375 @@
+package rpc
+
+import (
+    "context"
+    "sync"
+)
+
+const (
+    ServiceHealthCheck   = "rpc.health.check"
+    ServiceHealthWatch   = "rpc.health.watch"
+    ServiceHealthUnwatch = "rpc.health.unwatch"
+    serviceHealthUpdate  = "rpc.health.update"
+)
+
+// HealthStatus 与 gRPC 健康检查的状态一致
+type HealthStatus int
+
+const (
+    HealthUnknown HealthStatus = iota
+    HealthServing
+    HealthNotServing
+    HealthServiceUnknown
+)
+
+var healthStatusNames = map[HealthStatus]string{
+    HealthUnknown:        "UNKNOWN",
+    HealthServing:        "SERVING",
+    HealthNotServing:     "NOT_SERVING",
+    HealthServiceUnknown: "SERVICE_UNKNOWN",
+}
+
+func (s HealthStatus) String() string {
+    if name, ok := healthStatusNames[s]; ok {
+        return name
+    }
+    return "UNKNOWN"
+}
+
+type (
+    // HealthRequest 的 Service 为空时查询整个服务器的状态
+    HealthRequest struct {
+        Service string `msgpack:"service" json:"service"`
+    }
+    // HealthReply 的 Seq 随状态变化递增, 通知可能乱序到达, 客户端据此丢弃过期的状态
+    HealthReply struct {
+        Service string       `msgpack:"service" json:"service"`
+        Status  HealthStatus `msgpack:"status" json:"status"`
+        Seq     uint64       `msgpack:"seq" json:"seq"`
+    }
+    // HealthServer 维护服务器和各服务的健康状态, 状态变化时推送给 Watch 的连接
+    // 未单独设置状态的已注册服务跟随服务器的整体状态, 未注册的服务为 HealthServiceUnknown
+    HealthServer struct {
+        servant  *Servant
+        mutex    sync.Mutex
+        overall  HealthStatus
+        statuses map[string]HealthStatus
+        watchers map[uint64]*healthWatcher
+        seq      uint64
+    }
+    healthWatcher struct {
+        conn *Conn
+        last map[string]HealthStatus
+    }
+)
+
+// EnableHealth 注册健康检查服务, 整体状态初始为 HealthServing, Shutdown 时自动切换为 HealthNotServing
+func (s *Server) EnableHealth() *HealthServer {
+    if s.health != nil {
+        return s.health
+    }
+    h := &HealthServer{
+        servant:  s.servant,
+        overall:  HealthServing,
+        statuses: make(map[string]HealthStatus),
+        watchers: make(map[uint64]*healthWatcher),
+    }
+    s.servant.Register(ServiceHealthCheck, h.handleCheck)
+    s.servant.Register(ServiceHealthWatch, h.handleWatch)
+    s.servant.Register(ServiceHealthUnwatch, h.handleUnwatch)
+    s.health = h
+    return h
+}
+
+// Health 返回 EnableHealth 创建的 HealthServer, 未开启时为 nil
+func (s *Server) Health() *HealthServer {
+    return s.health
+}
+
+// SetServingStatus 设置服务的状态, service 为空时设置整体状态
+func (h *HealthServer) SetServingStatus(service string, status HealthStatus) {
+    h.mutex.Lock()
+    if service == "" {
+        h.overall = status
+    } else {
+        h.statuses[service] = status
+    }
+    h.mutex.Unlock()
+    h.notify()
+}
+
+// ClearStatus 清除服务单独设置的状态, 之后跟随整体状态
+func (h *HealthServer) ClearStatus(service string) {
+    h.mutex.Lock()
+    delete(h.statuses, service)
+    h.mutex.Unlock()
+    h.notify()
+}
+
+// Shutdown 将整体和所有服务的状态设为 HealthNotServing
+func (h *HealthServer) Shutdown() {
+    if h == nil {
+        return
+    }
+    h.mutex.Lock()
+    h.overall = HealthNotServing
+    for service := range h.statuses {
+        h.statuses[service] = HealthNotServing
+    }
+    h.mutex.Unlock()
+    h.notify()
+}
+
+func (h *HealthServer) Status(service string) HealthStatus {
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+    return h.status(service)
+}
+
+func (h *HealthServer) status(service string) HealthStatus {
+    if service == "" {
+        return h.overall
+    }
+    if status, ok := h.statuses[service]; ok {
+        if h.overall != HealthServing {
+            return h.overall
+        }
+        return status
+    }
+    if _, ok := h.servant.handler[service]; ok {
+        return h.overall
+    }
+    return HealthServiceUnknown
+}
+
+// notify 向状态发生变化的 Watch 推送新状态
+func (h *HealthServer) notify() {
+    type update struct {
+        conn  *Conn
+        reply *HealthReply
+    }
+    var updates []update
+    h.mutex.Lock()
+    h.seq++
+    for _, w := range h.watchers {
+        for service, last := range w.last {
+            if status := h.status(service); status != last {
+                w.last[service] = status
+                updates = append(updates, update{w.conn, &HealthReply{Service: service, Status: status, Seq: h.seq}})
+            }
+        }
+    }
+    h.mutex.Unlock()
+    for _, u := range updates {
+        _ = notifyAll([]*Conn{u.conn}, serviceHealthUpdate, u.reply)
+    }
+}
+
+func (h *HealthServer) removeConn(id uint64) {
+    if h == nil {
+        return
+    }
+    h.mutex.Lock()
+    delete(h.watchers, id)
+    h.mutex.Unlock()
+}
+
+func (h *HealthServer) handleCheck(ctx context.Context, req *HealthRequest, reply *HealthReply) error {
+    reply.Service = req.Service
+    reply.Status = h.Status(req.Service)
+    if reply.Status == HealthServiceUnknown {
+        return Errorf(CodeNotFound, "unknown service: %s", req.Service)
+    }
+    return nil
+}
+
+// handleWatch 登记连接的 Watch 并返回当前状态, 之后的变化以通知推送
+func (h *HealthServer) handleWatch(ctx context.Context, req *HealthRequest, reply *HealthReply) error {
+    p, ok := PeerFromContext(ctx)
+    if !ok || p.conn == nil {
+        return Errorf(CodeFailedPrecondition, "no connection")
+    }
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+    // 连接关闭后 removeConn 已经执行过, 此时登记的 Watch 不会再被清除
+    if p.conn.closed() {
+        return Errorf(CodeUnavailable, "connection closed")
+    }
+    w, ok := h.watchers[p.ConnID]
+    if !ok {
+        w = &healthWatcher{conn: p.conn, last: make(map[string]HealthStatus)}
+        h.watchers[p.ConnID] = w
+    }
+    reply.Service = req.Service
+    reply.Status = h.status(req.Service)
+    reply.Seq = h.seq
+    w.last[req.Service] = reply.Status
+    return nil
+}
+
+func (h *HealthServer) handleUnwatch(ctx context.Context, req *HealthRequest, _ *empty) error {
+    p, ok := PeerFromContext(ctx)
+    if !ok {
+        return Errorf(CodeFailedPrecondition, "no connection")
+    }
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+    if w, ok := h.watchers[p.ConnID]; ok {
+        delete(w.last, req.Service)
+        if len(w.last) == 0 {
+            delete(h.watchers, p.ConnID)
+        }
+    }
+    return nil
+}
+
+// HealthCheck 查询对端的健康状态, service 为空时查询整体状态
+func HealthCheck(ctx context.Context, c Callable, service string) (HealthStatus, error) {
+    reply := &HealthReply{}
+    if err := c.Call(ctx, ServiceHealthCheck, &HealthRequest{Service: service}, reply); err != nil {
+        if CodeOf(err) == CodeNotFound {
+            return HealthServiceUnknown, err
+        }
+        return HealthUnknown, err
+    }
+    return reply.Status, nil
+}
+
+type healthWatches struct {
+    delivery sync.Mutex
+    mutex    sync.Mutex
+    handlers map[string]map[int]func(status HealthStatus)
+    conn     *Conn
+    last     map[string]*HealthReply
+    nextId   int
+}
+
+func newHealthWatches() *healthWatches {
+    return &healthWatches{
+        handlers: make(map[string]map[int]func(status HealthStatus)),
+        last:     make(map[string]*HealthReply),
+    }
+}
+
+func (w *healthWatches) add(service string, fn func(status HealthStatus)) (id int, first bool) {
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+    handlers, ok := w.handlers[service]
+    if !ok {
+        handlers = make(map[int]func(status HealthStatus))
+        w.handlers[service] = handlers
+    }
+    w.nextId++
+    handlers[w.nextId] = fn
+    return w.nextId, len(handlers) == 1
+}
+
+func (w *healthWatches) remove(service string, id int) (last bool) {
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+    handlers, ok := w.handlers[service]
+    if !ok {
+        return false
+    }
+    delete(handlers, id)
+    if len(handlers) == 0 {
+        delete(w.handlers, service)
+        delete(w.last, service)
+        return true
+    }
+    return false
+}
+
+func (w *healthWatches) services() []string {
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+    result := make([]string, 0, len(w.handlers))
+    for service := range w.handlers {
+        result = append(result, service)
+    }
+    return result
+}
+
+// deliver 处理对端推送的状态通知
+func (w *healthWatches) deliver(ctx context.Context, reply *HealthReply, _ *empty) error {
+    var conn *Conn
+    if p, ok := PeerFromContext(ctx); ok {
+        conn = p.conn
+    }
+    w.update(conn, reply)
+    return nil
+}
+
+// update 把状态交给监听者, 过期或与上次相同的状态不重复通知
+// Seq 只在同一个连接内有序, 换了连接时清除上次的状态, 已断开的旧连接上迟到的通知直接丢弃
+func (w *healthWatches) update(conn *Conn, reply *HealthReply) {
+    w.delivery.Lock()
+    defer w.delivery.Unlock()
+    w.mutex.Lock()
+    if conn != w.conn {
+        if w.conn != nil && conn != nil && conn.closed() {
+            w.mutex.Unlock()
+            return
+        }
+        w.conn = conn
+        w.last = make(map[string]*HealthReply)
+    }
+    if last, ok := w.last[reply.Service]; ok && (reply.Seq < last.Seq || last.Status == reply.Status) {
+        w.mutex.Unlock()
+        return
+    }
+    if _, ok := w.handlers[reply.Service]; ok {
+        w.last[reply.Service] = reply
+    }
+    var matched []func(status HealthStatus)
+    for _, fn := range w.handlers[reply.Service] {
+        matched = append(matched, fn)
+    }
+    w.mutex.Unlock()
+    for _, fn := range matched {
+        fn(reply.Status)
+    }
+}
+
+// WatchHealth 监听对端的健康状态, fn 先收到当前状态, 之后在状态变化时被调用, ctx 结束时停止监听
+// 重连后会重新登记并收到当时的状态
+func (client *Client) WatchHealth(ctx context.Context, service string, fn func(status HealthStatus)) error {
+    id, first := client.health.add(service, fn)
+    if first {
+        conn, err := client.primary().get(client.dial)
+        if err != nil {
+            client.health.remove(service, id)
+            return connErrorf(true, "%v", err)
+        }
+        reply := &HealthReply{}
+        if err := client.callOn(ctx, client.primary(), ServiceHealthWatch, &HealthRequest{Service: service}, reply); err != nil {
+            client.health.remove(service, id)
+            return err
+        }
+        client.health.update(conn, reply)
+    } else {
+        status, err := HealthCheck(ctx, client, service)
+        if err != nil && status != HealthServiceUnknown {
+            client.health.remove(service, id)
+            return err
+        }
+        fn(status)
+    }
+    go func() {
+        <-ctx.Done()
+        if client.health.remove(service, id) {
+            _ = client.callOn(context.Background(), client.primary(), ServiceHealthUnwatch, &HealthRequest{Service: service}, &empty{})
+        }
+    }()
+    return nil
+}
+
+// rewatch 在重连后恢复 Watch, 并把新连接上当时的状态交给监听者
+func (client *Client) rewatch(conn *Conn) {
+    for _, service := range client.health.services() {
+        reply := &HealthReply{}
+        if err := client.callOn(context.Background(), client.primary(), ServiceHealthWatch, &HealthRequest{Service: service}, reply); err == nil {
+            client.health.update(conn, reply)
+        }
+    }
+}
@@ -0,0 +1,227 @@
+package rpc
+
+import (
+    "context"
+    "net"
+    "sync"
+    "testing"
+    "time"
+)
+
+// statusRecorder 记录 WatchHealth 收到的状态, 每次收到时关闭 changed 通知等待者
+type statusRecorder struct {
+    mutex    sync.Mutex
+    statuses []HealthStatus
+    changed  chan struct{}
+}
+
+func newStatusRecorder() *statusRecorder {
+    return &statusRecorder{changed: make(chan struct{})}
+}
+
+func (r *statusRecorder) add(status HealthStatus) {
+    r.mutex.Lock()
+    r.statuses = append(r.statuses, status)
+    close(r.changed)
+    r.changed = make(chan struct{})
+    r.mutex.Unlock()
+}
+
+// wait 等到最近一次收到的状态为 want, 超时只用于避免测试挂起
+func (r *statusRecorder) wait(t *testing.T, want HealthStatus) {
+    t.Helper()
+    timeout := time.After(10 * time.Second)
+    for {
+        r.mutex.Lock()
+        n := len(r.statuses)
+        changed := r.changed
+        r.mutex.Unlock()
+        if n > 0 && r.last() == want {
+            return
+        }
+        select {
+        case <-changed:
+        case <-timeout:
+            t.Fatalf("status = %v, want %v", r.last(), want)
+        }
+    }
+}
+
+func (r *statusRecorder) last() HealthStatus {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    if len(r.statuses) == 0 {
+        return HealthUnknown
+    }
+    return r.statuses[len(r.statuses)-1]
+}
+
+func (r *statusRecorder) count() int {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    return len(r.statuses)
+}
+
+func TestHealthCheck(t *testing.T) {
+    s, addr := startServer(t, func(s *Server) {
+        s.EnableHealth()
+    })
+    s.Health().SetServingStatus("echo", HealthNotServing)
+    c := newTestClient(t, addr)
+    ctx := context.Background()
+
+    cases := []struct {
+        service string
+        want    HealthStatus
+    }{
+        {"", HealthServing},
+        {"echo", HealthNotServing},
+        {"missing", HealthServiceUnknown},
+    }
+    for _, tc := range cases {
+        status, _ := HealthCheck(ctx, c, tc.service)
+        if status != tc.want {
+            t.Errorf("HealthCheck(%q) = %v, want %v", tc.service, status, tc.want)
+        }
+    }
+
+    s.Health().ClearStatus("echo")
+    if status, err := HealthCheck(ctx, c, "echo"); err != nil || status != HealthServing {
+        t.Fatalf("after ClearStatus = %v, %v", status, err)
+    }
+}
+
+func TestWatchHealth(t *testing.T) {
+    s, addr := startServer(t, func(s *Server) {
+        s.EnableHealth()
+    })
+    c := newTestClient(t, addr)
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    // 先建立连接, 避免首次连接触发的 rewatch 与 Shutdown 交错
+    if err := c.Call(ctx, "echo", &echoArgs{}, &echoArgs{}); err != nil {
+        t.Fatal(err)
+    }
+    rec := newStatusRecorder()
+    if err := c.WatchHealth(ctx, "echo", rec.add); err != nil {
+        t.Fatal(err)
+    }
+    if rec.last() != HealthServing {
+        t.Fatalf("first status = %v", rec.last())
+    }
+    s.Health().SetServingStatus("echo", HealthNotServing)
+    rec.wait(t, HealthNotServing)
+
+    // 状态没有变化时不重复通知
+    n := rec.count()
+    s.Health().SetServingStatus("echo", HealthNotServing)
+    s.Health().SetServingStatus("", HealthServing)
+    time.Sleep(50 * time.Millisecond)
+    if rec.count() != n {
+        t.Fatalf("got %d notifications, want %d", rec.count(), n)
+    }
+
+    shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second)
+    defer shutdownCancel()
+    s.Health().ClearStatus("echo")
+    rec.wait(t, HealthServing)
+    _ = s.Shutdown(shutdownCtx)
+    rec.wait(t, HealthNotServing)
+}
+
+// 重启后的服务器 Seq 从头开始, 重连后的状态不能被当作过期丢弃
+func TestWatchHealthAfterRestart(t *testing.T) {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    addr := ln.Addr().String()
+    s := NewP2PServer()
+    s.SetLogger(NopLogger())
+    s.Register("echo", echo)
+    h := s.EnableHealth()
+    go s.Serve(ln)
+
+    c := newTestClient(t, addr)
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    // 先建立连接, 避免首次连接触发的 rewatch 与 Shutdown 交错
+    if err := c.Call(ctx, "echo", &echoArgs{}, &echoArgs{}); err != nil {
+        t.Fatal(err)
+    }
+    rec := newStatusRecorder()
+    if err := c.WatchHealth(ctx, "echo", rec.add); err != nil {
+        t.Fatal(err)
+    }
+    for i := 0; i < 10; i++ {
+        h.SetServingStatus("echo", HealthNotServing)
+        h.SetServingStatus("echo", HealthServing)
+    }
+    rec.wait(t, HealthServing)
+
+    shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second)
+    defer shutdownCancel()
+    _ = s.Shutdown(shutdownCtx)
+    rec.wait(t, HealthNotServing)
+
+    s2, _ := startServerOn(t, addr, func(s *Server) {
+        s.EnableHealth()
+    })
+    eventually(t, 5*time.Second, func() bool {
+        reply := &echoArgs{}
+        return c.Call(context.Background(), "echo", &echoArgs{Name: "ping"}, reply) == nil
+    })
+    rec.wait(t, HealthServing)
+
+    s2.Health().SetServingStatus("echo", HealthNotServing)
+    rec.wait(t, HealthNotServing)
+}
+
+func startServerOn(t *testing.T, addr string, setup func(s *Server)) (*Server, string) {
+    t.Helper()
+    var (
+        ln  net.Listener
+        err error
+    )
+    eventually(t, time.Second, func() bool {
+        ln, err = net.Listen("tcp", addr)
+        return err == nil
+    })
+    s := NewP2PServer()
+    s.SetLogger(NopLogger())
+    s.Register("echo", echo)
+    if setup != nil {
+        setup(s)
+    }
+    go s.Serve(ln)
+    t.Cleanup(func() {
+        ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+        defer cancel()
+        _ = s.Shutdown(ctx)
+    })
+    return s, addr
+}
+
+func TestHealthWatchClosedConn(t *testing.T) {
+    s := NewP2PServer()
+    h := s.EnableHealth()
+    local, remote := net.Pipe()
+    defer remote.Close()
+    conn := NewConn(local, &sync.WaitGroup{})
+    cli := &acceptClient{conn: conn}
+    p := newPeer(conn, cli)
+    conn.Close()
+    h.removeConn(p.ConnID)
+
+    ctx := newPeerContext(context.Background(), p)
+    err := h.handleWatch(ctx, &HealthRequest{Service: ""}, &HealthReply{})
+    if CodeOf(err) != CodeUnavailable {
+        t.Fatalf("err = %v", err)
+    }
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+    if len(h.watchers) != 0 {
+        t.Fatalf("watchers = %v", h.watchers)
+    }
+}
@@ -0,0 +1,180 @@
+package rpc
+
+import (
+    "context"
+    "sort"
+    "sync"
+    "time"
+)
+
+const latencySamples = 1000
+
+type (
+    // HedgePolicy 描述只读服务的对冲请求
+    // 调用在延迟内未完成时向另一个后端发送相同请求, 采用最先成功的响应并取消其它请求.
+    // Percentile 大于 0 且样本足够时, 延迟取该服务近期耗时的对应分位数, 否则使用 Delay
+    HedgePolicy struct {
+        Delay       time.Duration
+        Percentile  float64
+        MaxAttempts int
+    }
+    latencyTracker struct {
+        mutex   sync.Mutex
+        samples []time.Duration
+        next    int
+    }
+)
+
+func (t *latencyTracker) add(d time.Duration) {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    if len(t.samples) < latencySamples {
+        t.samples = append(t.samples, d)
+        return
+    }
+    t.samples[t.next] = d
+    t.next = (t.next + 1) % latencySamples
+}
+
+func (t *latencyTracker) percentile(p float64) (time.Duration, bool) {
+    t.mutex.Lock()
+    if len(t.samples) < 20 {
+        t.mutex.Unlock()
+        return 0, false
+    }
+    sorted := append([]time.Duration(nil), t.samples...)
+    t.mutex.Unlock()
+    sort.Slice(sorted, func(i, j int) bool {
+        return sorted[i] < sorted[j]
+    })
+    i := int(p * float64(len(sorted)))
+    if i >= len(sorted) {
+        i = len(sorted) - 1
+    }
+    return sorted[i], true
+}
+
+func (c *BalancedClient) latency(service string) *latencyTracker {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    if c.latencies == nil {
+        c.latencies = make(map[string]*latencyTracker)
+    }
+    t, ok := c.latencies[service]
+    if !ok {
+        t = &latencyTracker{}
+        c.latencies[service] = t
+    }
+    return t
+}
+
+func (c *BalancedClient) hedgeDelay(policy *HedgePolicy, tracker *latencyTracker) time.Duration {
+    if policy.Percentile > 0 {
+        if d, ok := tracker.percentile(policy.Percentile); ok {
+            return d
+        }
+    }
+    return policy.Delay
+}
+
+func (c *BalancedClient) hedgedCall(ctx context.Context, policy *HedgePolicy, service string, args interface{}, reply interface{}) error {
+    type result struct {
+        raw RawMessage
+        err error
+    }
+    maxAttempts := policy.MaxAttempts
+    if maxAttempts <= 0 {
+        maxAttempts = 2
+    }
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+
+    tracker := c.latency(service)
+    info := PickInfo{Ctx: ctx, Service: service, Args: args}
+    tried := make(map[*Backend]bool)
+    results := make(chan result, maxAttempts)
+    launch := func() error {
+        b, err := c.pickExcluding(info, tried)
+        if err != nil {
+            return err
+        }
+        tried[b] = true
+        go func() {
+            var raw RawMessage
+            start := time.Now()
+            err := c.callBackend(ctx, b, service, args, &raw)
+            if err == nil {
+                tracker.add(time.Since(start))
+            }
+            results <- result{raw: raw, err: err}
+        }()
+        return nil
+    }
+
+    if err := launch(); err != nil {
+        return err
+    }
+    attempts, pending := 1, 1
+    timer := time.NewTimer(c.hedgeDelay(policy, tracker))
+    defer timer.Stop()
+    var lastErr error
+    for {
+        select {
+        case r := <-results:
+            pending--
+            if r.err == nil {
+                return Unmarshal(r.raw, reply)
+            }
+            lastErr = r.err
+            // 失败时立即尝试下一个后端
+            if attempts < maxAttempts && launch() == nil {
+                attempts++
+                pending++
+            } else if pending == 0 {
+                return lastErr
+            }
+        case <-timer.C:
+            if attempts < maxAttempts && launch() == nil {
+                attempts++
+                pending++
+                timer.Reset(c.hedgeDelay(policy, tracker))
+            }
+        case <-ctx.Done():
+            return contextError(ctx.Err())
+        }
+    }
+}
+
+func (c *BalancedClient) pickExcluding(info PickInfo, exclude map[*Backend]bool) (*Backend, error) {
+    info.Backends = c.Backends()
+    var (
+        candidates []*Backend
+        total      int
+        open       int
+    )
+    for _, b := range info.Backends {
+        if exclude[b] {
+            continue
+        }
+        total++
+        if !c.Breaker.available(endpointBreakerName(b.Addr)) {
+            open++
+            continue
+        }
+        if !b.Ejected() {
+            candidates = append(candidates, b)
+        }
+    }
+    if len(candidates) == 0 {
+        // 所有后端都被熔断时返回 ErrCircuitOpen, 调用方可以用 errors.Is 区分
+        if open > 0 && open == total {
+            return nil, ErrCircuitOpen
+        }
+        return nil, unsentErrorf(CodeUnavailable, "no available endpoint")
+    }
+    b := c.Balancer.Pick(info, candidates)
+    if b == nil {
+        return nil, unsentErrorf(CodeUnavailable, "no available endpoint")
+    }
+    return b, nil
+}
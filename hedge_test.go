@@ -0,0 +1,77 @@
+package rpc
+
+import (
+    "context"
+    "strconv"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestLatencyPercentile(t *testing.T) {
+    tracker := &latencyTracker{}
+    for i := 1; i < 20; i++ {
+        tracker.add(time.Duration(i) * time.Millisecond)
+    }
+    if _, ok := tracker.percentile(0.9); ok {
+        t.Fatal("percentile with too few samples")
+    }
+    for i := 20; i <= 100; i++ {
+        tracker.add(time.Duration(i) * time.Millisecond)
+    }
+    tests := []struct {
+        p    float64
+        want time.Duration
+    }{
+        {0.5, 51 * time.Millisecond},
+        {0.9, 91 * time.Millisecond},
+        {1, 100 * time.Millisecond},
+    }
+    for _, tt := range tests {
+        if got, _ := tracker.percentile(tt.p); got != tt.want {
+            t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+        }
+    }
+}
+
+func TestHedgedCall(t *testing.T) {
+    var cancelled int32
+    addrs := make([]string, 2)
+    for i := range addrs {
+        delay := time.Duration(0)
+        if i == 0 {
+            delay = time.Second
+        }
+        name := strconv.Itoa(i)
+        _, addrs[i] = startServer(t, func(s *Server) {
+            s.Register("who", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+                select {
+                case <-time.After(delay):
+                case <-ctx.Done():
+                    atomic.AddInt32(&cancelled, 1)
+                    return ctx.Err()
+                }
+                reply.Name = name
+                return nil
+            })
+        })
+    }
+    c := newTestBalancedClient(t, addrs...)
+    c.Hedge = map[string]*HedgePolicy{"who": {Delay: 20 * time.Millisecond}}
+
+    for i := 0; i < 4; i++ {
+        start := time.Now()
+        var reply echoArgs
+        if err := c.Call(context.Background(), "who", &echoArgs{}, &reply); err != nil {
+            t.Fatal(err)
+        }
+        if reply.Name != "1" {
+            t.Fatalf("call %d served by %s", i, reply.Name)
+        }
+        if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+            t.Fatalf("call %d took %v", i, elapsed)
+        }
+    }
+    // 先到的响应胜出后慢的请求被取消
+    eventually(t, time.Second, func() bool { return atomic.LoadInt32(&cancelled) > 0 })
+}
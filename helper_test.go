@@ -0,0 +1,61 @@
+package rpc
+
+import (
+    "context"
+    "net"
+    "testing"
+    "time"
+)
+
+type echoArgs struct {
+    Name string
+    N    int
+}
+
+func echo(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+    reply.Name = args.Name
+    reply.N = args.N
+    return nil
+}
+
+// startServer 在随机端口启动服务器, 测试结束时关闭
+func startServer(t *testing.T, setup func(s *Server)) (*Server, string) {
+    t.Helper()
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    s := NewP2PServer()
+    s.SetLogger(NopLogger())
+    s.Register("echo", echo)
+    if setup != nil {
+        setup(s)
+    }
+    go s.Serve(ln)
+    t.Cleanup(func() {
+        ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+        defer cancel()
+        _ = s.Shutdown(ctx)
+    })
+    return s, ln.Addr().String()
+}
+
+func newTestClient(t *testing.T, addr string) *Client {
+    t.Helper()
+    c := NewP2PClient(addr)
+    c.SetLogger(NopLogger())
+    t.Cleanup(c.Close)
+    return c
+}
+
+// eventually 在 timeout 内反复检查 cond
+func eventually(t *testing.T, timeout time.Duration, cond func() bool) {
+    t.Helper()
+    deadline := time.Now().Add(timeout)
+    for !cond() {
+        if time.Now().After(deadline) {
+            t.Fatal("condition not met")
+        }
+        time.Sleep(5 * time.Millisecond)
+    }
+}
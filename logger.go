@@ -0,0 +1,166 @@
+package rpc
+
+import (
+    "context"
+    "fmt"
+    "github.com/DGHeroin/rpc/pb"
+    "log"
+    "os"
+    "strings"
+    "time"
+)
+
+// Level 是日志级别, 取值与 log/slog 相同
+type Level int
+
+const (
+    LevelDebug Level = -4
+    LevelInfo  Level = 0
+    LevelWarn  Level = 4
+    LevelError Level = 8
+)
+
+type (
+    // Logger 是结构化日志接口, args 为交替的键值对, *slog.Logger 可以直接使用
+    Logger interface {
+        Debug(msg string, args ...interface{})
+        Info(msg string, args ...interface{})
+        Warn(msg string, args ...interface{})
+        Error(msg string, args ...interface{})
+    }
+    // StdLogger 将日志以 key=value 格式写入标准库 log.Logger, 低于 Level 的日志被丢弃
+    StdLogger struct {
+        Logger *log.Logger
+        Level  Level
+    }
+    nopLogger struct{}
+)
+
+var defaultLogger Logger = NewStdLogger(log.New(os.Stderr, "", log.LstdFlags), LevelWarn)
+
+func NewStdLogger(l *log.Logger, level Level) *StdLogger {
+    return &StdLogger{Logger: l, Level: level}
+}
+
+// NopLogger 返回丢弃所有日志的 Logger
+func NopLogger() Logger {
+    return nopLogger{}
+}
+
+func (l *StdLogger) Debug(msg string, args ...interface{}) {
+    l.log(LevelDebug, msg, args)
+}
+
+func (l *StdLogger) Info(msg string, args ...interface{}) {
+    l.log(LevelInfo, msg, args)
+}
+
+func (l *StdLogger) Warn(msg string, args ...interface{}) {
+    l.log(LevelWarn, msg, args)
+}
+
+func (l *StdLogger) Error(msg string, args ...interface{}) {
+    l.log(LevelError, msg, args)
+}
+
+func (l *StdLogger) log(level Level, msg string, args []interface{}) {
+    if level < l.Level {
+        return
+    }
+    var b strings.Builder
+    b.WriteString("level=")
+    b.WriteString(level.String())
+    b.WriteString(" msg=")
+    b.WriteString(quote(msg))
+    for i := 0; i < len(args); i += 2 {
+        b.WriteByte(' ')
+        if i+1 >= len(args) {
+            b.WriteString("!BADKEY=")
+            b.WriteString(quote(fmt.Sprint(args[i])))
+            break
+        }
+        b.WriteString(fmt.Sprint(args[i]))
+        b.WriteByte('=')
+        b.WriteString(quote(fmt.Sprint(args[i+1])))
+    }
+    _ = l.Logger.Output(3, b.String())
+}
+
+func (l Level) String() string {
+    switch {
+    case l < LevelInfo:
+        return "DEBUG"
+    case l < LevelWarn:
+        return "INFO"
+    case l < LevelError:
+        return "WARN"
+    default:
+        return "ERROR"
+    }
+}
+
+func quote(s string) string {
+    if s == "" || strings.ContainsAny(s, " =\"\n\t") {
+        return fmt.Sprintf("%q", s)
+    }
+    return s
+}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+// logger 返回设置的 Logger, 未设置时写入标准错误, 只输出 Warn 以上的日志
+func (s *Servant) logger() Logger {
+    if s.log != nil {
+        return s.log
+    }
+    return defaultLogger
+}
+
+func (s *Servant) SetLogger(l Logger) {
+    s.log = l
+}
+
+// SetAccessLog 开启后每次处理请求都以 Info 级别记录服务名, 请求 id, 错误码和耗时
+func (s *Servant) SetAccessLog(enabled bool) {
+    s.accessLog = enabled
+}
+
+func (s *Servant) logAccess(ctx context.Context, req *pb.Message, err error, elapsed time.Duration) {
+    args := []interface{}{
+        "service", req.GetName(),
+        "request_id", req.GetId(),
+        "code", CodeOf(err).String(),
+        "elapsed", elapsed,
+    }
+    if p, ok := PeerFromContext(ctx); ok {
+        args = append(args, "conn_id", p.ConnID, "peer", p.RemoteAddr)
+    }
+    s.logger().Info("rpc call", args...)
+}
+
+func (s *Server) SetLogger(l Logger) {
+    s.servant.SetLogger(l)
+}
+
+func (s *Server) SetAccessLog(enabled bool) {
+    s.servant.SetAccessLog(enabled)
+}
+
+func (client *Client) SetLogger(l Logger) {
+    client.servant.SetLogger(l)
+}
+
+func (client *Client) SetAccessLog(enabled bool) {
+    client.servant.SetAccessLog(enabled)
+}
+
+func (c *BalancedClient) SetLogger(l Logger) {
+    c.servant.SetLogger(l)
+}
+
+func (c *BalancedClient) SetAccessLog(enabled bool) {
+    c.servant.SetAccessLog(enabled)
+}
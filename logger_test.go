@@ -0,0 +1,119 @@
+package rpc
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "log"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestStdLogger(t *testing.T) {
+    tests := []struct {
+        level Level
+        msg   string
+        args  []interface{}
+        want  string
+    }{
+        {LevelDebug, "dropped", nil, ""},
+        {LevelInfo, "hello", nil, "level=INFO msg=hello\n"},
+        {LevelWarn, "two words", []interface{}{"k", "v"}, `level=WARN msg="two words" k=v` + "\n"},
+        {LevelError, "e", []interface{}{"n", 1, "s", "a b", "empty", ""}, `level=ERROR msg=e n=1 s="a b" empty=""` + "\n"},
+        {LevelInfo, "odd", []interface{}{"k", "v", "lonely"}, "level=INFO msg=odd k=v !BADKEY=lonely\n"},
+    }
+    for _, tt := range tests {
+        var buf bytes.Buffer
+        l := NewStdLogger(log.New(&buf, "", 0), LevelInfo)
+        switch tt.level {
+        case LevelDebug:
+            l.Debug(tt.msg, tt.args...)
+        case LevelInfo:
+            l.Info(tt.msg, tt.args...)
+        case LevelWarn:
+            l.Warn(tt.msg, tt.args...)
+        case LevelError:
+            l.Error(tt.msg, tt.args...)
+        }
+        if got := buf.String(); got != tt.want {
+            t.Errorf("got %q, want %q", got, tt.want)
+        }
+    }
+}
+
+type logEntry struct {
+    level Level
+    msg   string
+    args  []interface{}
+}
+
+// captureLogger 保存所有日志
+type captureLogger struct {
+    mutex   sync.Mutex
+    entries []logEntry
+}
+
+func (l *captureLogger) add(level Level, msg string, args []interface{}) {
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+    l.entries = append(l.entries, logEntry{level, msg, args})
+}
+
+func (l *captureLogger) Debug(msg string, args ...interface{}) { l.add(LevelDebug, msg, args) }
+func (l *captureLogger) Info(msg string, args ...interface{})  { l.add(LevelInfo, msg, args) }
+func (l *captureLogger) Warn(msg string, args ...interface{})  { l.add(LevelWarn, msg, args) }
+func (l *captureLogger) Error(msg string, args ...interface{}) { l.add(LevelError, msg, args) }
+
+func (l *captureLogger) find(msg string) (logEntry, bool) {
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+    for _, e := range l.entries {
+        if e.msg == msg {
+            return e, true
+        }
+    }
+    return logEntry{}, false
+}
+
+func TestServerLogging(t *testing.T) {
+    logger := &captureLogger{}
+    _, addr := startServer(t, func(s *Server) {
+        s.SetLogger(logger)
+        s.SetAccessLog(true)
+        s.Register("boom", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+            panic("boom")
+        })
+    })
+    c := newTestClient(t, addr)
+    ctx := context.Background()
+    _ = c.Call(ctx, "echo", &echoArgs{}, &echoArgs{})
+    _ = c.Call(ctx, "boom", &echoArgs{}, &echoArgs{})
+    _ = c.Call(ctx, "missing", &echoArgs{}, &echoArgs{})
+
+    tests := []struct {
+        msg   string
+        level Level
+        text  string
+    }{
+        {"connection opened", LevelDebug, "conn_id"},
+        {"rpc call", LevelInfo, "service echo"},
+        {"handler panic", LevelError, "service boom"},
+        {"service not found", LevelWarn, "service missing"},
+    }
+    for _, tt := range tests {
+        var e logEntry
+        eventually(t, time.Second, func() bool {
+            var ok bool
+            e, ok = logger.find(tt.msg)
+            return ok
+        })
+        if e.level != tt.level {
+            t.Errorf("%s: level = %s, want %s", tt.msg, e.level, tt.level)
+        }
+        if args := fmt.Sprintln(e.args...); !strings.Contains(args, tt.text) {
+            t.Errorf("%s: args = %s, want %q", tt.msg, args, tt.text)
+        }
+    }
+}
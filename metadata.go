@@ -0,0 +1,74 @@
+package rpc
+
+import (
+    "context"
+    "github.com/DGHeroin/rpc/pb"
+)
+
+const (
+    MetadataTimeout = "rpc-timeout"
+)
+
+// Metadata 是随请求传递的键值对, 以 rpc- 开头的键保留给框架使用
+type Metadata map[string]string
+
+type (
+    outgoingMetadataKey struct{}
+    incomingMetadataKey struct{}
+)
+
+func (md Metadata) Get(key string) string {
+    return md[key]
+}
+
+func (md Metadata) Copy() Metadata {
+    result := make(Metadata, len(md))
+    for k, v := range md {
+        result[k] = v
+    }
+    return result
+}
+
+// WithMetadata 返回携带 md 的 ctx, 与 ctx 中已有的元数据合并
+func WithMetadata(ctx context.Context, md Metadata) context.Context {
+    merged := OutgoingMetadata(ctx).Copy()
+    for k, v := range md {
+        merged[k] = v
+    }
+    return context.WithValue(ctx, outgoingMetadataKey{}, merged)
+}
+
+// AppendMetadata 在 ctx 的元数据中追加一个键值对
+func AppendMetadata(ctx context.Context, key string, value string) context.Context {
+    return WithMetadata(ctx, Metadata{key: value})
+}
+
+// OutgoingMetadata 返回 ctx 中将随请求发出的元数据
+func OutgoingMetadata(ctx context.Context) Metadata {
+    md, _ := ctx.Value(outgoingMetadataKey{}).(Metadata)
+    return md
+}
+
+// IncomingMetadata 返回处理函数收到的请求元数据
+func IncomingMetadata(ctx context.Context) Metadata {
+    md, _ := ctx.Value(incomingMetadataKey{}).(Metadata)
+    return md
+}
+
+func newIncomingContext(ctx context.Context, msg *pb.Message) context.Context {
+    dict := msg.GetDict()
+    if dict == nil || len(dict.Values) == 0 {
+        return ctx
+    }
+    md := make(Metadata, len(dict.Values))
+    for _, kv := range dict.Values {
+        md[kv.GetKey()] = string(kv.Value)
+    }
+    return context.WithValue(ctx, incomingMetadataKey{}, md)
+}
+
+func putMetadata(msg *pb.Message, md Metadata) {
+    for k, v := range md {
+        dictSet(msg, k, []byte(v))
+    }
+}
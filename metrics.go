@@ -0,0 +1,119 @@
+package rpc
+
+import (
+    "sync/atomic"
+    "time"
+)
+
+// Side 区分调用或连接属于发起方还是处理方
+type Side string
+
+const (
+    SideClient Side = "client"
+    SideServer Side = "server"
+)
+
+type (
+    // Metrics 接收调用和连接的统计, 实现需要并发安全
+    // 发出的调用以 SideClient 上报, 处理的请求和通知以 SideServer 上报,
+    // 连接按拨号方和监听方区分. 进行中的调用数为 CallStarted 与 CallFinished 之差
+    Metrics interface {
+        CallStarted(side Side, service string)
+        CallFinished(side Side, service string, code Code, elapsed time.Duration)
+        ConnOpened(side Side)
+        ConnClosed(side Side)
+        FrameRead(side Side, bytes int)
+        FrameWritten(side Side, bytes int)
+    }
+    // ConnStats 是单个连接的读写统计
+    ConnStats struct {
+        Created       time.Time
+        BytesRead     uint64
+        BytesWritten  uint64
+        FramesRead    uint64
+        FramesWritten uint64
+    }
+    connCounters struct {
+        bytesRead     uint64
+        bytesWritten  uint64
+        framesRead    uint64
+        framesWritten uint64
+    }
+    nopMetrics struct{}
+)
+
+func (nopMetrics) CallStarted(Side, string)                       {}
+func (nopMetrics) CallFinished(Side, string, Code, time.Duration) {}
+func (nopMetrics) ConnOpened(Side)                                {}
+func (nopMetrics) ConnClosed(Side)                                {}
+func (nopMetrics) FrameRead(Side, int)                            {}
+func (nopMetrics) FrameWritten(Side, int)                         {}
+
+func metricsOrNop(m Metrics) Metrics {
+    if m == nil {
+        return nopMetrics{}
+    }
+    return m
+}
+
+// instrument 设置连接的统计上报, 须在 Do 之前调用
+func (c *Conn) instrument(m Metrics, side Side) {
+    c.metrics = metricsOrNop(m)
+    c.side = side
+    c.metrics.ConnOpened(side)
+}
+
+func (c *Conn) countRead(n int) {
+    atomic.AddUint64(&c.counters.bytesRead, uint64(n))
+    atomic.AddUint64(&c.counters.framesRead, 1)
+    c.metrics.FrameRead(c.side, n)
+}
+
+func (c *Conn) countWritten(n int) {
+    atomic.AddUint64(&c.counters.bytesWritten, uint64(n))
+    atomic.AddUint64(&c.counters.framesWritten, 1)
+    c.metrics.FrameWritten(c.side, n)
+}
+
+func (c *Conn) Stats() ConnStats {
+    return ConnStats{
+        Created:       c.created,
+        BytesRead:     atomic.LoadUint64(&c.counters.bytesRead),
+        BytesWritten:  atomic.LoadUint64(&c.counters.bytesWritten),
+        FramesRead:    atomic.LoadUint64(&c.counters.framesRead),
+        FramesWritten: atomic.LoadUint64(&c.counters.framesWritten),
+    }
+}
+
+// Pending 返回等待响应的调用数
+func (mgr *CallManager) Pending() int {
+    mgr.mutex.Lock()
+    defer mgr.mutex.Unlock()
+    return len(mgr.reqMap)
+}
+
+// startCall 上报调用开始, 返回的函数在调用结束时上报结果和耗时
+func startCall(m Metrics, side Side, service string) func(err error) {
+    m = metricsOrNop(m)
+    m.CallStarted(side, service)
+    start := time.Now()
+    return func(err error) {
+        m.CallFinished(side, service, CodeOf(err), time.Since(start))
+    }
+}
+
+func (s *Servant) SetMetrics(m Metrics) {
+    s.metrics = m
+}
+
+func (s *Server) SetMetrics(m Metrics) {
+    s.servant.SetMetrics(m)
+}
+
+func (client *Client) SetMetrics(m Metrics) {
+    client.servant.SetMetrics(m)
+}
+
+func (c *BalancedClient) SetMetrics(m Metrics) {
+    c.servant.SetMetrics(m)
+}
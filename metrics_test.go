@@ -0,0 +1,137 @@
+package rpc
+
+import (
+    "context"
+    "sync"
+    "testing"
+    "time"
+)
+
+type recordedCall struct {
+    side    Side
+    service string
+    code    Code
+}
+
+// recordingMetrics 记录上报的调用和连接数
+type recordingMetrics struct {
+    mutex    sync.Mutex
+    started  map[Side]int
+    finished []recordedCall
+    conns    map[Side]int
+    frames   map[Side]int
+}
+
+func newRecordingMetrics() *recordingMetrics {
+    return &recordingMetrics{
+        started: make(map[Side]int),
+        conns:   make(map[Side]int),
+        frames:  make(map[Side]int),
+    }
+}
+
+func (m *recordingMetrics) CallStarted(side Side, service string) {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+    m.started[side]++
+}
+
+func (m *recordingMetrics) CallFinished(side Side, service string, code Code, elapsed time.Duration) {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+    m.finished = append(m.finished, recordedCall{side, service, code})
+}
+
+func (m *recordingMetrics) ConnOpened(side Side) {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+    m.conns[side]++
+}
+
+func (m *recordingMetrics) ConnClosed(side Side) {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+    m.conns[side]--
+}
+
+func (m *recordingMetrics) FrameRead(side Side, bytes int) {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+    m.frames[side]++
+}
+
+func (m *recordingMetrics) FrameWritten(side Side, bytes int) {}
+
+func (m *recordingMetrics) count(call recordedCall) int {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+    n := 0
+    for _, c := range m.finished {
+        if c == call {
+            n++
+        }
+    }
+    return n
+}
+
+func (m *recordingMetrics) openConns(side Side) int {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+    return m.conns[side]
+}
+
+func TestMetrics(t *testing.T) {
+    m := newRecordingMetrics()
+    s, addr := startServer(t, func(s *Server) {
+        s.SetMetrics(m)
+    })
+    c := newTestClient(t, addr)
+    c.SetMetrics(m)
+
+    ctx := context.Background()
+    for i := 0; i < 3; i++ {
+        if err := c.Call(ctx, "echo", &echoArgs{}, &echoArgs{}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    _ = c.Call(ctx, "missing", &echoArgs{}, &echoArgs{})
+
+    tests := []struct {
+        call recordedCall
+        want int
+    }{
+        {recordedCall{SideClient, "echo", CodeOK}, 3},
+        {recordedCall{SideServer, "echo", CodeOK}, 3},
+        {recordedCall{SideClient, "missing", CodeNotFound}, 1},
+        {recordedCall{SideServer, "missing", CodeNotFound}, 1},
+    }
+    eventually(t, time.Second, func() bool { return m.count(tests[3].call) == 1 })
+    for _, tt := range tests {
+        if n := m.count(tt.call); n != tt.want {
+            t.Errorf("%+v reported %d times, want %d", tt.call, n, tt.want)
+        }
+    }
+    if m.openConns(SideClient) != 1 || m.openConns(SideServer) != 1 {
+        t.Fatalf("open conns = %v", m.conns)
+    }
+
+    conn, err := c.GetConn()
+    if err != nil {
+        t.Fatal(err)
+    }
+    st := conn.Stats()
+    if st.FramesWritten < 4 || st.FramesRead < 4 || st.BytesWritten == 0 || st.BytesRead == 0 {
+        t.Fatalf("conn stats = %+v", st)
+    }
+    callers := s.Connections()
+    if len(callers) != 1 {
+        t.Fatalf("server connections = %d", len(callers))
+    }
+    p, _ := PeerOf(callers[0])
+    if sst := p.conn.Stats(); sst.FramesRead < 4 || sst.BytesRead == 0 {
+        t.Fatalf("server conn stats = %+v", sst)
+    }
+
+    c.Close()
+    eventually(t, time.Second, func() bool { return m.openConns(SideClient) == 0 && m.openConns(SideServer) == 0 })
+}
@@ -0,0 +1,39 @@
+package rpc
+
+import (
+    "context"
+)
+
+type callFunc func(ctx context.Context, service string, args interface{}, reply interface{}) error
+
+// CallOptions 是 Client 和 BalancedClient 共用的调用策略
+// Breaker 同时用于服务级和后端级熔断
+type CallOptions struct {
+    Retry     *RetryPolicy
+    Breaker   *CircuitBreaker
+    RateLimit *RateLimiter
+    Coalesce  *Coalescer
+    Cache     *ResponseCache
+}
+
+// invoke 按配置的策略包装 call 后执行, 限速和服务级熔断在重试之外, 一次调用只计一次
+// 命中缓存或被合并的调用不再经过这些策略
+func (o *CallOptions) invoke(ctx context.Context, service string, args interface{}, reply interface{}, call callFunc) error {
+    return o.Cache.do(ctx, service, args, reply, func(ctx context.Context, service string, args interface{}, reply interface{}) error {
+        return o.Coalesce.do(ctx, service, args, reply, func(ctx context.Context, service string, args interface{}, reply interface{}) error {
+            return o.apply(ctx, service, args, reply, call)
+        })
+    })
+}
+
+func (o *CallOptions) apply(ctx context.Context, service string, args interface{}, reply interface{}, call callFunc) error {
+    if err := o.RateLimit.acquire(ctx, service); err != nil {
+        return err
+    }
+    if o.Retry != nil {
+        call = o.Retry.wrap(call)
+    }
+    return o.Breaker.do(serviceBreakerName(service), func() error {
+        return call(ctx, service, args, reply)
+    })
+}
@@ -1,12 +1,23 @@
 package rpc
 
-import "time"
+import (
+    "net"
+    "time"
+)
 
 func NewP2PServer() *Server {
-    return &Server{
+    srv := &Server{
         ReadWriteTimeout: time.Second * 10,
+        Broker:           newBroker(),
         servant:          NewServant(),
+        conns:            make(map[uint64]*acceptClient),
+        groups:           make(map[string]map[uint64]*acceptClient),
+        listeners:        make(map[net.Listener]Endpoint),
     }
+    srv.servant.Register(serviceSubscribe, srv.Broker.handleSubscribe)
+    srv.servant.Register(serviceUnsubscribe, srv.Broker.handleUnsubscribe)
+    srv.servant.Register(servicePublish, srv.Broker.handlePublish)
+    return srv
 }
 
 func NewP2PClient(addr string) *Client {
@@ -15,6 +26,11 @@ func NewP2PClient(addr string) *Client {
         servant:          NewServant(),
         addr:             addr,
         mgr:              newCallManager(),
+        subs:             newSubscriptions(),
+        health:           newHealthWatches(),
     }
+    cli.servant.Register(serviceMessage, cli.subs.deliver)
+    cli.servant.Register(serviceInvalidate, cli.CallOptions.handleInvalidate)
+    cli.servant.Register(serviceHealthUpdate, cli.health.deliver)
     return cli
 }
@@ -0,0 +1,61 @@
+package rpc
+
+import (
+    "context"
+    "crypto/tls"
+    "net"
+)
+
+// Peer 描述一次调用的对端连接
+type Peer struct {
+    ConnID     uint64
+    RemoteAddr net.Addr
+    LocalAddr  net.Addr
+    TLS        *tls.ConnectionState
+    Identity   string
+    Callable   Callable
+    Session    *Session
+    conn       *Conn
+    requests   *inflight
+}
+
+type peerKey struct{}
+
+func newPeerContext(ctx context.Context, p *Peer) context.Context {
+    return context.WithValue(ctx, peerKey{}, p)
+}
+
+// PeerFromContext 返回处理函数 ctx 中的对端信息
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+    p, ok := ctx.Value(peerKey{}).(*Peer)
+    return p, ok
+}
+
+// PeerOf 返回 Server.OnOpen/OnClose 回调中 Callable 对应的对端信息
+func PeerOf(caller Callable) (*Peer, bool) {
+    c, ok := caller.(interface{ Peer() *Peer })
+    if !ok {
+        return nil, false
+    }
+    return c.Peer(), true
+}
+
+func newPeer(c *Conn, caller Callable) *Peer {
+    p := &Peer{
+        ConnID:     c.ID(),
+        RemoteAddr: c.RemoteAddr(),
+        LocalAddr:  c.LocalAddr(),
+        Callable:   caller,
+        Session:    newSession(),
+        conn:       c,
+        requests:   newInflight(),
+    }
+    if tlsConn, ok := c.conn.(*tls.Conn); ok {
+        state := tlsConn.ConnectionState()
+        p.TLS = &state
+        if len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+            p.Identity = state.VerifiedChains[0][0].Subject.CommonName
+        }
+    }
+    return p
+}
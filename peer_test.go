@@ -0,0 +1,51 @@
+package rpc
+
+import (
+    "context"
+    "testing"
+)
+
+func TestPeerFromContext(t *testing.T) {
+    opened := make(chan *Peer, 1)
+    _, addr := startServer(t, func(s *Server) {
+        s.OnOpen(func(caller Callable) {
+            p, _ := PeerOf(caller)
+            opened <- p
+        })
+        s.Register("whoami", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+            p, ok := PeerFromContext(ctx)
+            if !ok {
+                return Errorf(CodeInternal, "no peer")
+            }
+            reply.Name = p.RemoteAddr.String()
+            reply.N = int(p.ConnID)
+            // 通过对端回调客户端
+            return p.Callable.Call(ctx, "ping", args, &echoArgs{})
+        })
+    })
+    c := newTestClient(t, addr)
+    pinged := make(chan string, 1)
+    c.Register("ping", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+        pinged <- args.Name
+        return nil
+    })
+
+    var reply echoArgs
+    if err := c.Call(context.Background(), "whoami", &echoArgs{Name: "hi"}, &reply); err != nil {
+        t.Fatal(err)
+    }
+    conn, err := c.GetConn()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if reply.Name != conn.LocalAddr().String() {
+        t.Fatalf("remote addr = %s, want %s", reply.Name, conn.LocalAddr())
+    }
+    if got := <-pinged; got != "hi" {
+        t.Fatalf("callback args = %q", got)
+    }
+    p := <-opened
+    if p == nil || int(p.ConnID) != reply.N || p.TLS != nil {
+        t.Fatalf("OnOpen peer = %+v, conn id %d", p, reply.N)
+    }
+}
@@ -0,0 +1,138 @@
+package rpc
+
+import (
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+type PoolPolicy int
+
+const (
+    PoolRoundRobin   PoolPolicy = iota // 轮流使用连接
+    PoolLeastPending                   // 使用未完成调用最少的连接
+)
+
+type (
+    // PoolStats 是连接池中单个连接槽位的统计
+    PoolStats struct {
+        Index      int
+        ConnID     uint64
+        Connected  bool
+        Pending    int64
+        Calls      uint64
+        Errors     uint64
+        Reconnects uint64
+        Since      time.Time
+    }
+    pooledConn struct {
+        index      int
+        mutex      sync.Mutex
+        conn       *Conn
+        since      time.Time
+        pending    int64
+        calls      uint64
+        errors     uint64
+        reconnects uint64
+        dialed     bool
+    }
+    connPool struct {
+        slots []*pooledConn
+        next  uint32
+    }
+)
+
+func newConnPool(size int) *connPool {
+    if size <= 0 {
+        size = 1
+    }
+    p := &connPool{
+        slots: make([]*pooledConn, size),
+    }
+    for i := range p.slots {
+        p.slots[i] = &pooledConn{index: i}
+    }
+    return p
+}
+
+func (p *connPool) pick(policy PoolPolicy) *pooledConn {
+    if len(p.slots) == 1 {
+        return p.slots[0]
+    }
+    n := int(atomic.AddUint32(&p.next, 1))
+    switch policy {
+    case PoolLeastPending:
+        // 从轮转位置开始扫描, 未完成数相同时分散到不同连接
+        var best *pooledConn
+        for i := range p.slots {
+            slot := p.slots[(n+i)%len(p.slots)]
+            if best == nil || atomic.LoadInt64(&slot.pending) < atomic.LoadInt64(&best.pending) {
+                best = slot
+            }
+        }
+        return best
+    default:
+        return p.slots[n%len(p.slots)]
+    }
+}
+
+func (p *connPool) stats() []PoolStats {
+    result := make([]PoolStats, 0, len(p.slots))
+    for _, slot := range p.slots {
+        slot.mutex.Lock()
+        st := PoolStats{
+            Index:      slot.index,
+            Connected:  slot.conn != nil,
+            Pending:    atomic.LoadInt64(&slot.pending),
+            Calls:      atomic.LoadUint64(&slot.calls),
+            Errors:     atomic.LoadUint64(&slot.errors),
+            Reconnects: atomic.LoadUint64(&slot.reconnects),
+            Since:      slot.since,
+        }
+        if slot.conn != nil {
+            st.ConnID = slot.conn.ID()
+        }
+        slot.mutex.Unlock()
+        result = append(result, st)
+    }
+    return result
+}
+
+func (p *connPool) closeAll() {
+    for _, slot := range p.slots {
+        slot.mutex.Lock()
+        conn := slot.conn
+        slot.mutex.Unlock()
+        if conn != nil {
+            conn.Close()
+        }
+    }
+}
+
+// get 返回槽位上的连接, 连接断开后由 dial 重新建立
+func (slot *pooledConn) get(dial func(slot *pooledConn) (*Conn, error)) (*Conn, error) {
+    slot.mutex.Lock()
+    defer slot.mutex.Unlock()
+    if slot.conn != nil && !slot.conn.closed() {
+        return slot.conn, nil
+    }
+    conn, err := dial(slot)
+    if err != nil {
+        return nil, err
+    }
+    if slot.dialed {
+        atomic.AddUint64(&slot.reconnects, 1)
+    }
+    slot.dialed = true
+    slot.conn = conn
+    slot.since = time.Now()
+    return conn, nil
+}
+
+func (slot *pooledConn) release(conn *Conn) {
+    slot.mutex.Lock()
+    if slot.conn == conn {
+        slot.conn = nil
+    }
+    slot.mutex.Unlock()
+}
@@ -0,0 +1,77 @@
+package rpc
+
+import (
+    "context"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestClientPool(t *testing.T) {
+    s, addr := startServer(t, nil)
+    c := newTestClient(t, addr)
+    c.PoolSize = 3
+
+    var wg sync.WaitGroup
+    for i := 0; i < 30; i++ {
+        wg.Add(1)
+        go func(n int) {
+            defer wg.Done()
+            var reply echoArgs
+            if err := c.Call(context.Background(), "echo", &echoArgs{N: n}, &reply); err != nil || reply.N != n {
+                t.Errorf("call %d: %v %v", n, err, reply)
+            }
+        }(i)
+    }
+    wg.Wait()
+
+    stats := c.PoolStats()
+    if len(stats) != 3 {
+        t.Fatalf("pool size = %d", len(stats))
+    }
+    for _, st := range stats {
+        if !st.Connected || st.Calls != 10 {
+            t.Errorf("slot %d: connected %v, calls %d", st.Index, st.Connected, st.Calls)
+        }
+    }
+    if n := len(s.Connections()); n != 3 {
+        t.Fatalf("server connections = %d", n)
+    }
+}
+
+func TestClientPoolReconnect(t *testing.T) {
+    s, addr := startServer(t, nil)
+    c := newTestClient(t, addr)
+    c.PoolSize = 2
+    c.PoolPolicy = PoolLeastPending
+
+    var reply echoArgs
+    for i := 0; i < 2; i++ {
+        if err := c.Call(context.Background(), "echo", &echoArgs{}, &reply); err != nil {
+            t.Fatal(err)
+        }
+    }
+    // 服务端断开所有连接后, 槽位按需重连
+    for _, caller := range s.Connections() {
+        p, _ := PeerOf(caller)
+        p.conn.Close()
+    }
+    eventually(t, time.Second, func() bool {
+        for _, st := range c.PoolStats() {
+            if st.Connected {
+                return false
+            }
+        }
+        return true
+    })
+    if err := c.Call(context.Background(), "echo", &echoArgs{N: 7}, &reply); err != nil || reply.N != 7 {
+        t.Fatalf("call after reconnect: %v %v", err, reply)
+    }
+    var reconnects uint64
+    for _, st := range c.PoolStats() {
+        reconnects += st.Reconnects
+    }
+    if reconnects == 0 {
+        t.Fatal("no reconnect recorded")
+    }
+}
@@ -0,0 +1,138 @@
+// Package promrpc 将 rpc 的调用和连接统计导出为 Prometheus 指标
+package promrpc
+
+import (
+    "github.com/DGHeroin/rpc"
+    "github.com/prometheus/client_golang/prometheus"
+    "time"
+)
+
+// Metrics 实现 rpc.Metrics 和 prometheus.Collector
+//
+//	srv.SetMetrics(m)
+//	prometheus.MustRegister(m)
+type Metrics struct {
+    started       *prometheus.CounterVec
+    completed     *prometheus.CounterVec
+    failed        *prometheus.CounterVec
+    latency       *prometheus.HistogramVec
+    pending       *prometheus.GaugeVec
+    conns         *prometheus.GaugeVec
+    bytesRead     *prometheus.CounterVec
+    bytesWritten  *prometheus.CounterVec
+    framesRead    *prometheus.CounterVec
+    framesWritten *prometheus.CounterVec
+}
+
+var _ rpc.Metrics = (*Metrics)(nil)
+
+// New 创建指标, namespace 为指标名前缀, 为空时使用 rpc
+func New(namespace string) *Metrics {
+    if namespace == "" {
+        namespace = "rpc"
+    }
+    return &Metrics{
+        started: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Namespace: namespace,
+            Name:      "calls_started_total",
+            Help:      "Total number of calls started.",
+        }, []string{"side", "service"}),
+        completed: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Namespace: namespace,
+            Name:      "calls_completed_total",
+            Help:      "Total number of calls completed, by code.",
+        }, []string{"side", "service", "code"}),
+        failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Namespace: namespace,
+            Name:      "calls_failed_total",
+            Help:      "Total number of calls completed with a non-OK code.",
+        }, []string{"side", "service", "code"}),
+        latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+            Namespace: namespace,
+            Name:      "call_duration_seconds",
+            Help:      "Call latency in seconds.",
+            Buckets:   prometheus.DefBuckets,
+        }, []string{"side", "service"}),
+        pending: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+            Namespace: namespace,
+            Name:      "calls_pending",
+            Help:      "Number of calls in flight.",
+        }, []string{"side"}),
+        conns: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+            Namespace: namespace,
+            Name:      "connections_open",
+            Help:      "Number of open connections.",
+        }, []string{"side"}),
+        bytesRead: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Namespace: namespace,
+            Name:      "read_bytes_total",
+            Help:      "Total bytes read from connections.",
+        }, []string{"side"}),
+        bytesWritten: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Namespace: namespace,
+            Name:      "written_bytes_total",
+            Help:      "Total bytes written to connections.",
+        }, []string{"side"}),
+        framesRead: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Namespace: namespace,
+            Name:      "read_frames_total",
+            Help:      "Total frames read from connections.",
+        }, []string{"side"}),
+        framesWritten: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Namespace: namespace,
+            Name:      "written_frames_total",
+            Help:      "Total frames written to connections.",
+        }, []string{"side"}),
+    }
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+    return []prometheus.Collector{
+        m.started, m.completed, m.failed, m.latency, m.pending,
+        m.conns, m.bytesRead, m.bytesWritten, m.framesRead, m.framesWritten,
+    }
+}
+
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+    for _, c := range m.collectors() {
+        c.Describe(ch)
+    }
+}
+
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+    for _, c := range m.collectors() {
+        c.Collect(ch)
+    }
+}
+
+func (m *Metrics) CallStarted(side rpc.Side, service string) {
+    m.started.WithLabelValues(string(side), service).Inc()
+    m.pending.WithLabelValues(string(side)).Inc()
+}
+
+func (m *Metrics) CallFinished(side rpc.Side, service string, code rpc.Code, elapsed time.Duration) {
+    m.pending.WithLabelValues(string(side)).Dec()
+    m.completed.WithLabelValues(string(side), service, code.String()).Inc()
+    if code != rpc.CodeOK {
+        m.failed.WithLabelValues(string(side), service, code.String()).Inc()
+    }
+    m.latency.WithLabelValues(string(side), service).Observe(elapsed.Seconds())
+}
+
+func (m *Metrics) ConnOpened(side rpc.Side) {
+    m.conns.WithLabelValues(string(side)).Inc()
+}
+
+func (m *Metrics) ConnClosed(side rpc.Side) {
+    m.conns.WithLabelValues(string(side)).Dec()
+}
+
+func (m *Metrics) FrameRead(side rpc.Side, bytes int) {
+    m.bytesRead.WithLabelValues(string(side)).Add(float64(bytes))
+    m.framesRead.WithLabelValues(string(side)).Inc()
+}
+
+func (m *Metrics) FrameWritten(side rpc.Side, bytes int) {
+    m.bytesWritten.WithLabelValues(string(side)).Add(float64(bytes))
+    m.framesWritten.WithLabelValues(string(side)).Inc()
+}
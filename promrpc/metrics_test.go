@@ -0,0 +1,82 @@
+package promrpc
+
+import (
+    "context"
+    "github.com/DGHeroin/rpc"
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/testutil"
+    "net"
+    "testing"
+    "time"
+)
+
+type echoArgs struct {
+    N int
+}
+
+func TestMetrics(t *testing.T) {
+    m := New("test")
+    registry := prometheus.NewRegistry()
+    registry.MustRegister(m)
+
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    srv := rpc.NewP2PServer()
+    srv.SetLogger(rpc.NopLogger())
+    srv.SetMetrics(m)
+    srv.Register("echo", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+        reply.N = args.N
+        return nil
+    })
+    go srv.Serve(ln)
+    defer srv.Shutdown(context.Background())
+
+    cli := rpc.NewP2PClient(ln.Addr().String())
+    cli.SetLogger(rpc.NopLogger())
+    cli.SetMetrics(m)
+    defer cli.Close()
+    for i := 0; i < 2; i++ {
+        if err := cli.Call(context.Background(), "echo", &echoArgs{N: i}, &echoArgs{}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    _ = cli.Call(context.Background(), "missing", &echoArgs{}, &echoArgs{})
+
+    tests := []struct {
+        name      string
+        collector prometheus.Collector
+        want      float64
+    }{
+        {"client started", m.started.WithLabelValues("client", "echo"), 2},
+        {"client completed", m.completed.WithLabelValues("client", "echo", "OK"), 2},
+        {"server completed", m.completed.WithLabelValues("server", "echo", "OK"), 2},
+        {"client failed", m.failed.WithLabelValues("client", "missing", "NotFound"), 1},
+        {"client pending", m.pending.WithLabelValues("client"), 0},
+        {"client conns", m.conns.WithLabelValues("client"), 1},
+        {"server conns", m.conns.WithLabelValues("server"), 1},
+    }
+    deadline := time.Now().Add(time.Second)
+    for _, tt := range tests {
+        for testutil.ToFloat64(tt.collector) != tt.want && time.Now().Before(deadline) {
+            time.Sleep(5 * time.Millisecond)
+        }
+        if got := testutil.ToFloat64(tt.collector); got != tt.want {
+            t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+        }
+    }
+    if testutil.ToFloat64(m.bytesWritten.WithLabelValues("client")) == 0 {
+        t.Error("no bytes written")
+    }
+    if n := testutil.CollectAndCount(m, "test_call_duration_seconds"); n != 4 {
+        t.Errorf("latency series = %d", n)
+    }
+    problems, err := testutil.GatherAndLint(registry)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, p := range problems {
+        t.Errorf("lint: %s: %s", p.Metric, p.Text)
+    }
+}
@@ -0,0 +1,422 @@
+package rpc
+
+import (
+    "context"
+    "strings"
+    "sync"
+    "sync/atomic"
+)
+
+const (
+    serviceSubscribe   = "rpc.subscribe"
+    serviceUnsubscribe = "rpc.unsubscribe"
+    servicePublish     = "rpc.publish"
+    serviceMessage     = "rpc.message"
+)
+
+type OverflowPolicy int
+
+const (
+    DropNewest OverflowPolicy = iota // 队列满时丢弃新消息
+    DropOldest                       // 队列满时丢弃最旧的消息
+    Disconnect                       // 队列满时断开订阅者连接
+)
+
+type (
+    // Publication 是一条发布到主题的消息
+    Publication struct {
+        Topic string
+        Data  []byte
+    }
+    subscribeRequest struct {
+        Topic string
+    }
+    empty struct{}
+
+    // Broker 在服务端按主题将消息路由给订阅的连接
+    // 主题以 . 分隔, 订阅时 * 匹配一段, > 匹配剩余的一段或多段
+    Broker struct {
+        QueueSize int
+        Overflow  OverflowPolicy
+        mutex     sync.RWMutex
+        topics    map[string]map[uint64]*subscriber
+        subs      map[uint64]*subscriber
+        dropped   uint64
+    }
+    subscriber struct {
+        conn      *Conn
+        queue     chan []byte
+        topics    map[string]struct{}
+        closeCh   chan struct{}
+        closeOnce sync.Once
+    }
+)
+
+func (p *Publication) Decode(ptr interface{}) error {
+    return Unmarshal(p.Data, ptr)
+}
+
+func newBroker() *Broker {
+    return &Broker{
+        QueueSize: 128,
+        Overflow:  DropNewest,
+        topics:    make(map[string]map[uint64]*subscriber),
+        subs:      make(map[uint64]*subscriber),
+    }
+}
+
+// Dropped 返回因订阅者队列满而丢弃的消息数
+func (b *Broker) Dropped() uint64 {
+    return atomic.LoadUint64(&b.dropped)
+}
+
+// subscribe 在连接已断开时返回 false, OnClose 先关闭连接再调用 removeConn, 所以持锁检查后登记的订阅一定会被清除
+func (b *Broker) subscribe(conn *Conn, topic string) bool {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    if conn.closed() {
+        return false
+    }
+    sub, ok := b.subs[conn.ID()]
+    if !ok {
+        size := b.QueueSize
+        if size <= 0 {
+            size = 1
+        }
+        sub = &subscriber{
+            conn:    conn,
+            queue:   make(chan []byte, size),
+            topics:  make(map[string]struct{}),
+            closeCh: make(chan struct{}),
+        }
+        b.subs[conn.ID()] = sub
+        go sub.writeLoop()
+    }
+    sub.topics[topic] = struct{}{}
+    members, ok := b.topics[topic]
+    if !ok {
+        members = make(map[uint64]*subscriber)
+        b.topics[topic] = members
+    }
+    members[conn.ID()] = sub
+    return true
+}
+
+func (b *Broker) unsubscribe(id uint64, topic string) {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    sub, ok := b.subs[id]
+    if !ok {
+        return
+    }
+    delete(sub.topics, topic)
+    if members, ok := b.topics[topic]; ok {
+        delete(members, id)
+        if len(members) == 0 {
+            delete(b.topics, topic)
+        }
+    }
+    if len(sub.topics) == 0 {
+        delete(b.subs, id)
+        sub.close()
+    }
+}
+
+func (b *Broker) removeConn(id uint64) {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    sub, ok := b.subs[id]
+    if !ok {
+        return
+    }
+    for topic := range sub.topics {
+        if members, ok := b.topics[topic]; ok {
+            delete(members, id)
+            if len(members) == 0 {
+                delete(b.topics, topic)
+            }
+        }
+    }
+    delete(b.subs, id)
+    sub.close()
+}
+
+// Publish 将消息路由给所有匹配主题的订阅者, 每个连接只投递一次
+func (b *Broker) Publish(topic string, msg interface{}) error {
+    data, err := Marshal(msg)
+    if err != nil {
+        return err
+    }
+    return b.publish(topic, data)
+}
+
+func (b *Broker) publish(topic string, data []byte) error {
+    b.mutex.RLock()
+    targets := make(map[uint64]*subscriber)
+    for pattern, members := range b.topics {
+        if !topicMatch(pattern, topic) {
+            continue
+        }
+        for id, sub := range members {
+            targets[id] = sub
+        }
+    }
+    b.mutex.RUnlock()
+    if len(targets) == 0 {
+        return nil
+    }
+
+    msg, err := buildNotify(serviceMessage, &Publication{Topic: topic, Data: data})
+    if err != nil {
+        return err
+    }
+    bin, err := makePkt(3, msg)
+    if err != nil {
+        return err
+    }
+    for _, sub := range targets {
+        b.enqueue(sub, bin)
+    }
+    return nil
+}
+
+func (b *Broker) enqueue(sub *subscriber, bin []byte) {
+    for {
+        select {
+        case sub.queue <- bin:
+            return
+        default:
+        }
+        atomic.AddUint64(&b.dropped, 1)
+        switch b.Overflow {
+        case DropOldest:
+            select {
+            case <-sub.queue:
+            default:
+            }
+        case Disconnect:
+            sub.conn.Close()
+            return
+        default:
+            return
+        }
+    }
+}
+
+func (b *Broker) handleSubscribe(ctx context.Context, req *subscribeRequest, _ *empty) error {
+    p, ok := PeerFromContext(ctx)
+    if !ok || p.conn == nil {
+        return Errorf(CodeFailedPrecondition, "no connection")
+    }
+    if !validTopic(req.Topic, true) {
+        return Errorf(CodeInvalidArgument, "bad topic: %q", req.Topic)
+    }
+    if !b.subscribe(p.conn, req.Topic) {
+        return Errorf(CodeUnavailable, "connection closed")
+    }
+    return nil
+}
+
+func (b *Broker) handleUnsubscribe(ctx context.Context, req *subscribeRequest, _ *empty) error {
+    p, ok := PeerFromContext(ctx)
+    if !ok {
+        return Errorf(CodeFailedPrecondition, "no connection")
+    }
+    b.unsubscribe(p.ConnID, req.Topic)
+    return nil
+}
+
+func (b *Broker) handlePublish(ctx context.Context, req *Publication, _ *empty) error {
+    if !validTopic(req.Topic, false) {
+        return Errorf(CodeInvalidArgument, "bad topic: %q", req.Topic)
+    }
+    return b.publish(req.Topic, req.Data)
+}
+
+func (sub *subscriber) writeLoop() {
+    for {
+        select {
+        case <-sub.closeCh:
+            return
+        case <-sub.conn.closeCh:
+            return
+        case bin := <-sub.queue:
+            if err := sub.conn.SendRaw(bin); err != nil {
+                sub.conn.Close()
+                return
+            }
+        }
+    }
+}
+
+func (sub *subscriber) close() {
+    sub.closeOnce.Do(func() {
+        close(sub.closeCh)
+    })
+}
+
+// validTopic 检查主题格式, 只有订阅时允许通配符
+func validTopic(topic string, wildcard bool) bool {
+    if topic == "" {
+        return false
+    }
+    tokens := strings.Split(topic, ".")
+    for i, token := range tokens {
+        switch {
+        case token == "":
+            return false
+        case token == "*":
+            if !wildcard {
+                return false
+            }
+        case token == ">":
+            if !wildcard || i != len(tokens)-1 {
+                return false
+            }
+        }
+    }
+    return true
+}
+
+func topicMatch(pattern string, topic string) bool {
+    if pattern == topic {
+        return true
+    }
+    p := strings.Split(pattern, ".")
+    t := strings.Split(topic, ".")
+    for i, token := range p {
+        if token == ">" {
+            return len(t) > i
+        }
+        if i >= len(t) {
+            return false
+        }
+        if token != "*" && token != t[i] {
+            return false
+        }
+    }
+    return len(p) == len(t)
+}
+
+type (
+    subscriptions struct {
+        mutex    sync.RWMutex
+        handlers map[string][]subscription
+        nextId   int
+    }
+    subscription struct {
+        id int
+        fn func(msg *Publication)
+    }
+)
+
+func newSubscriptions() *subscriptions {
+    return &subscriptions{
+        handlers: make(map[string][]subscription),
+    }
+}
+
+func (s *subscriptions) add(topic string, handler func(msg *Publication)) (id int, first bool) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    s.nextId++
+    first = len(s.handlers[topic]) == 0
+    s.handlers[topic] = append(s.handlers[topic], subscription{id: s.nextId, fn: handler})
+    return s.nextId, first
+}
+
+// removeHandler 只移除一个 handler, 返回主题是否已经没有 handler
+func (s *subscriptions) removeHandler(topic string, id int) (last bool) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    handlers := s.handlers[topic]
+    for i, sub := range handlers {
+        if sub.id == id {
+            handlers = append(handlers[:i:i], handlers[i+1:]...)
+            break
+        }
+    }
+    if len(handlers) == 0 {
+        delete(s.handlers, topic)
+        return true
+    }
+    s.handlers[topic] = handlers
+    return false
+}
+
+func (s *subscriptions) remove(topic string) bool {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    _, ok := s.handlers[topic]
+    delete(s.handlers, topic)
+    return ok
+}
+
+func (s *subscriptions) topics() []string {
+    s.mutex.RLock()
+    defer s.mutex.RUnlock()
+    result := make([]string, 0, len(s.handlers))
+    for topic := range s.handlers {
+        result = append(result, topic)
+    }
+    return result
+}
+
+func (s *subscriptions) deliver(ctx context.Context, msg *Publication, _ *empty) error {
+    s.mutex.RLock()
+    var matched []func(msg *Publication)
+    for pattern, handlers := range s.handlers {
+        if topicMatch(pattern, msg.Topic) {
+            for _, sub := range handlers {
+                matched = append(matched, sub.fn)
+            }
+        }
+    }
+    s.mutex.RUnlock()
+    for _, handler := range matched {
+        handler(msg)
+    }
+    return nil
+}
+
+// Subscribe 订阅主题, 同一主题的多个 handler 共享一次服务端订阅
+func (client *Client) Subscribe(ctx context.Context, topic string, handler func(msg *Publication)) error {
+    if !validTopic(topic, true) {
+        return Errorf(CodeInvalidArgument, "bad topic: %q", topic)
+    }
+    id, first := client.subs.add(topic, handler)
+    if !first {
+        return nil
+    }
+    err := client.callOn(ctx, client.primary(), serviceSubscribe, &subscribeRequest{Topic: topic}, &empty{})
+    if err != nil {
+        client.subs.removeHandler(topic, id)
+    }
+    return err
+}
+
+func (client *Client) Unsubscribe(ctx context.Context, topic string) error {
+    if !client.subs.remove(topic) {
+        return nil
+    }
+    return client.callOn(ctx, client.primary(), serviceUnsubscribe, &subscribeRequest{Topic: topic}, &empty{})
+}
+
+func (client *Client) Publish(topic string, msg interface{}) error {
+    data, err := Marshal(msg)
+    if err != nil {
+        return err
+    }
+    return client.Call(context.Background(), servicePublish, &Publication{Topic: topic, Data: data}, &empty{})
+}
+
+// resubscribe 在重连后恢复服务端订阅
+func (client *Client) resubscribe() {
+    for _, topic := range client.subs.topics() {
+        _ = client.callOn(context.Background(), client.primary(), serviceSubscribe, &subscribeRequest{Topic: topic}, &empty{})
+    }
+}
+
+func (s *Server) Publish(topic string, msg interface{}) error {
+    return s.Broker.Publish(topic, msg)
+}
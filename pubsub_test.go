@@ -0,0 +1,169 @@
+package rpc
+
+import (
+    "context"
+    "net"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestValidTopic(t *testing.T) {
+    tests := []struct {
+        topic    string
+        wildcard bool
+        want     bool
+    }{
+        {"a", false, true},
+        {"a.b.c", false, true},
+        {"", false, false},
+        {"a..b", false, false},
+        {".a", false, false},
+        {"a.", false, false},
+        {"a.*", false, false},
+        {"a.*", true, true},
+        {"a.>", false, false},
+        {"a.>", true, true},
+        {">", true, true},
+        {"a.>.b", true, false},
+        {"*.b.*", true, true},
+    }
+    for _, tt := range tests {
+        if got := validTopic(tt.topic, tt.wildcard); got != tt.want {
+            t.Errorf("validTopic(%q, %v) = %v, want %v", tt.topic, tt.wildcard, got, tt.want)
+        }
+    }
+}
+
+func TestTopicMatch(t *testing.T) {
+    tests := []struct {
+        pattern string
+        topic   string
+        want    bool
+    }{
+        {"a.b", "a.b", true},
+        {"a.b", "a.c", false},
+        {"a.b", "a.b.c", false},
+        {"a.*", "a.b", true},
+        {"a.*", "a", false},
+        {"a.*", "a.b.c", false},
+        {"*.b", "a.b", true},
+        {"a.>", "a.b", true},
+        {"a.>", "a.b.c", true},
+        {"a.>", "a", false},
+        {">", "a", true},
+        {"a.*.c", "a.b.c", true},
+        {"a.*.c", "a.b.d", false},
+    }
+    for _, tt := range tests {
+        if got := topicMatch(tt.pattern, tt.topic); got != tt.want {
+            t.Errorf("topicMatch(%q, %q) = %v, want %v", tt.pattern, tt.topic, got, tt.want)
+        }
+    }
+}
+
+func TestPubSub(t *testing.T) {
+    s, addr := startServer(t, nil)
+    sub := newTestClient(t, addr)
+    pub := newTestClient(t, addr)
+
+    received := make(chan string, 16)
+    handler := func(msg *Publication) {
+        var args echoArgs
+        if err := msg.Decode(&args); err != nil {
+            t.Error(err)
+        }
+        received <- msg.Topic + ":" + args.Name
+    }
+    ctx := context.Background()
+    if err := sub.Subscribe(ctx, "room.*.chat", handler); err != nil {
+        t.Fatal(err)
+    }
+    if err := sub.Subscribe(ctx, "a..b", handler); CodeOf(err) != CodeInvalidArgument {
+        t.Fatalf("bad topic err = %v", err)
+    }
+
+    if err := pub.Publish("room.1.chat", &echoArgs{Name: "hi"}); err != nil {
+        t.Fatal(err)
+    }
+    if err := pub.Publish("room.1.move", &echoArgs{Name: "skip"}); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.Publish("room.2.chat", &echoArgs{Name: "server"}); err != nil {
+        t.Fatal(err)
+    }
+    want := []string{"room.1.chat:hi", "room.2.chat:server"}
+    for _, w := range want {
+        select {
+        case got := <-received:
+            if got != w {
+                t.Fatalf("got %q, want %q", got, w)
+            }
+        case <-time.After(time.Second):
+            t.Fatalf("timeout waiting for %q", w)
+        }
+    }
+
+    if err := sub.Unsubscribe(ctx, "room.*.chat"); err != nil {
+        t.Fatal(err)
+    }
+    _ = pub.Publish("room.1.chat", &echoArgs{Name: "late"})
+    select {
+    case got := <-received:
+        t.Fatalf("received %q after unsubscribe", got)
+    case <-time.After(50 * time.Millisecond):
+    }
+}
+
+// 连接关闭后才执行的订阅不能留下无人清除的订阅者
+func TestBrokerSubscribeClosedConn(t *testing.T) {
+    b := newBroker()
+    local, remote := net.Pipe()
+    defer remote.Close()
+    conn := NewConn(local, &sync.WaitGroup{})
+    conn.Close()
+    b.removeConn(conn.ID())
+    if b.subscribe(conn, "room.1") {
+        t.Fatal("subscribed a closed connection")
+    }
+    b.mutex.RLock()
+    defer b.mutex.RUnlock()
+    if len(b.subs) != 0 || len(b.topics) != 0 {
+        t.Fatalf("subs = %v, topics = %v", b.subs, b.topics)
+    }
+}
+
+func TestSubscriptionsRemoveHandler(t *testing.T) {
+    s := newSubscriptions()
+    var got []string
+    id1, first := s.add("t", func(msg *Publication) { got = append(got, "a") })
+    if !first {
+        t.Fatal("first handler not reported as first")
+    }
+    _, first = s.add("t", func(msg *Publication) { got = append(got, "b") })
+    if first {
+        t.Fatal("second handler reported as first")
+    }
+    // 订阅失败时只移除自己的 handler
+    if s.removeHandler("t", id1) {
+        t.Fatal("topic reported empty with a handler left")
+    }
+    _ = s.deliver(context.Background(), &Publication{Topic: "t"}, nil)
+    if len(got) != 1 || got[0] != "b" {
+        t.Fatalf("delivered to %v", got)
+    }
+}
+
+func TestSubscribeFailureKeepsNoHandler(t *testing.T) {
+    _, addr := startServer(t, nil)
+    c := newTestClient(t, addr)
+    // 服务端拒绝的订阅不保留 handler
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if err := c.Subscribe(ctx, "room.1", func(msg *Publication) {}); err == nil {
+        t.Fatal("subscribe with cancelled ctx succeeded")
+    }
+    if topics := c.subs.topics(); len(topics) != 0 {
+        t.Fatalf("topics = %v", topics)
+    }
+}
@@ -0,0 +1,267 @@
+package rpc
+
+import (
+    "context"
+    "github.com/DGHeroin/rpc/pb"
+    "google.golang.org/protobuf/proto"
+    "strconv"
+    "sync"
+    "time"
+)
+
+const (
+    MetadataRetryAfter = "rpc-retry-after"
+)
+
+type (
+    // Limit 是令牌桶限速, Rate 为每秒令牌数, Rate <= 0 表示不限速
+    Limit struct {
+        Rate  float64
+        Burst int
+    }
+    tokenBucket struct {
+        mutex  sync.Mutex
+        limit  Limit
+        tokens float64
+        last   time.Time
+    }
+    // RateLimiter 是客户端按服务名限速, 未设置的服务使用 Default
+    RateLimiter struct {
+        Default Limit
+        Wait    bool
+        mutex   sync.Mutex
+        limits  map[string]Limit
+        buckets map[string]*tokenBucket
+    }
+    // AdmissionPolicy 是服务端的准入限速, 超出时立即拒绝而不是排队
+    AdmissionPolicy struct {
+        PerConn     Limit
+        PerIdentity Limit
+        PerService  map[string]Limit
+    }
+    Admission struct {
+        Policy     AdmissionPolicy
+        mutex      sync.Mutex
+        conns      map[uint64]*tokenBucket
+        identities map[string]*tokenBucket
+        services   map[string]*tokenBucket
+        rejected   uint64
+    }
+)
+
+func (l Limit) unlimited() bool {
+    return l.Rate <= 0
+}
+
+func newTokenBucket(limit Limit) *tokenBucket {
+    burst := limit.Burst
+    if burst <= 0 {
+        burst = 1
+    }
+    limit.Burst = burst
+    return &tokenBucket{
+        limit:  limit,
+        tokens: float64(burst),
+        last:   time.Now(),
+    }
+}
+
+// take 取一个令牌, 失败时返回需要等待的时间
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    // now 可能早于桶的创建时间, 这时不补充令牌
+    if elapsed := now.Sub(b.last); elapsed > 0 {
+        b.tokens += elapsed.Seconds() * b.limit.Rate
+        if max := float64(b.limit.Burst); b.tokens > max {
+            b.tokens = max
+        }
+        b.last = now
+    }
+    if b.tokens >= 1 {
+        b.tokens--
+        return true, 0
+    }
+    wait := time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+    return false, wait
+}
+
+// refund 归还 take 取走的令牌
+func (b *tokenBucket) refund() {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    b.tokens++
+    if max := float64(b.limit.Burst); b.tokens > max {
+        b.tokens = max
+    }
+}
+
+func NewRateLimiter() *RateLimiter {
+    return &RateLimiter{
+        limits:  make(map[string]Limit),
+        buckets: make(map[string]*tokenBucket),
+    }
+}
+
+func (l *RateLimiter) SetLimit(service string, limit Limit) {
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+    if l.limits == nil {
+        l.limits = make(map[string]Limit)
+    }
+    l.limits[service] = limit
+    delete(l.buckets, service)
+}
+
+func (l *RateLimiter) bucket(service string) *tokenBucket {
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+    if b, ok := l.buckets[service]; ok {
+        return b
+    }
+    limit, ok := l.limits[service]
+    if !ok {
+        limit = l.Default
+    }
+    if limit.unlimited() {
+        return nil
+    }
+    b := newTokenBucket(limit)
+    if l.buckets == nil {
+        l.buckets = make(map[string]*tokenBucket)
+    }
+    l.buckets[service] = b
+    return b
+}
+
+// acquire 取得调用 service 的许可, Wait 为 true 时等待令牌直到 ctx 结束
+func (l *RateLimiter) acquire(ctx context.Context, service string) error {
+    if l == nil {
+        return nil
+    }
+    b := l.bucket(service)
+    if b == nil {
+        return nil
+    }
+    for {
+        ok, wait := b.take(time.Now())
+        if ok {
+            return nil
+        }
+        if !l.Wait {
+            return unsentErrorf(CodeResourceExhausted, "rate limit exceeded: %s", service)
+        }
+        select {
+        case <-time.After(wait):
+        case <-ctx.Done():
+            return contextError(ctx.Err())
+        }
+    }
+}
+
+func NewAdmission(policy AdmissionPolicy) *Admission {
+    return &Admission{
+        Policy:     policy,
+        conns:      make(map[uint64]*tokenBucket),
+        identities: make(map[string]*tokenBucket),
+        services:   make(map[string]*tokenBucket),
+    }
+}
+
+// Rejected 返回被拒绝的请求数
+func (a *Admission) Rejected() uint64 {
+    a.mutex.Lock()
+    defer a.mutex.Unlock()
+    return a.rejected
+}
+
+// admit 依次检查连接, 身份和服务的限速, 拒绝时归还已取的令牌并返回建议的重试等待时间
+func (a *Admission) admit(p *Peer, service string) (bool, time.Duration) {
+    now := time.Now()
+    a.mutex.Lock()
+    var buckets []*tokenBucket
+    if !a.Policy.PerConn.unlimited() {
+        if a.conns == nil {
+            a.conns = make(map[uint64]*tokenBucket)
+        }
+        buckets = append(buckets, a.bucketOf(a.conns, p.ConnID, a.Policy.PerConn))
+    }
+    if !a.Policy.PerIdentity.unlimited() && p.Identity != "" {
+        if a.identities == nil {
+            a.identities = make(map[string]*tokenBucket)
+        }
+        b, ok := a.identities[p.Identity]
+        if !ok {
+            b = newTokenBucket(a.Policy.PerIdentity)
+            a.identities[p.Identity] = b
+        }
+        buckets = append(buckets, b)
+    }
+    if limit, ok := a.Policy.PerService[service]; ok && !limit.unlimited() {
+        if a.services == nil {
+            a.services = make(map[string]*tokenBucket)
+        }
+        b, ok := a.services[service]
+        if !ok {
+            b = newTokenBucket(limit)
+            a.services[service] = b
+        }
+        buckets = append(buckets, b)
+    }
+    a.mutex.Unlock()
+
+    for i, b := range buckets {
+        if ok, wait := b.take(now); !ok {
+            // 被拒绝的请求不消耗前面的限额
+            for _, taken := range buckets[:i] {
+                taken.refund()
+            }
+            a.mutex.Lock()
+            a.rejected++
+            a.mutex.Unlock()
+            return false, wait
+        }
+    }
+    return true, 0
+}
+
+func (a *Admission) bucketOf(m map[uint64]*tokenBucket, id uint64, limit Limit) *tokenBucket {
+    b, ok := m[id]
+    if !ok {
+        b = newTokenBucket(limit)
+        m[id] = b
+    }
+    return b
+}
+
+func (a *Admission) removeConn(id uint64) {
+    if a == nil {
+        return
+    }
+    a.mutex.Lock()
+    delete(a.conns, id)
+    a.mutex.Unlock()
+}
+
+// RetryAfter 返回服务端拒绝请求时建议的重试等待时间
+func RetryAfter(err error) (time.Duration, bool) {
+    e := toError(err)
+    if e.retryAfter <= 0 {
+        return 0, false
+    }
+    return e.retryAfter, true
+}
+
+// newRejectReply 构造拒绝请求的回复, wait 为 0 时不建议重试时间
+func newRejectReply(req *pb.Message, err error, wait time.Duration) *pb.Message {
+    reply := &pb.Message{
+        Action: proto.Int32(2),
+        Id:     proto.Uint32(req.GetId()),
+    }
+    setReplyError(reply, err)
+    if wait > 0 {
+        ms := int64(wait/time.Millisecond) + 1
+        dictSet(reply, MetadataRetryAfter, []byte(strconv.FormatInt(ms, 10)))
+    }
+    return reply
+}
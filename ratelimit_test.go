@@ -0,0 +1,162 @@
+package rpc
+
+import (
+    "context"
+    "github.com/DGHeroin/rpc/pb"
+    "google.golang.org/protobuf/proto"
+    "testing"
+    "time"
+)
+
+func TestTokenBucket(t *testing.T) {
+    b := newTokenBucket(Limit{Rate: 10, Burst: 2})
+    now := time.Now()
+    tests := []struct {
+        after time.Duration
+        ok    bool
+    }{
+        {0, true},
+        {0, true},
+        {0, false},
+        {50 * time.Millisecond, false},
+        {100 * time.Millisecond, true},
+        {100 * time.Millisecond, false},
+        {time.Second, true},
+        {time.Second, true},
+        {time.Second, false},
+    }
+    for i, tt := range tests {
+        ok, wait := b.take(now.Add(tt.after))
+        if ok != tt.ok {
+            t.Fatalf("take %d: ok = %v", i, ok)
+        }
+        if !ok && (wait <= 0 || wait > 100*time.Millisecond) {
+            t.Fatalf("take %d: wait = %v", i, wait)
+        }
+    }
+}
+
+func TestRateLimiterZeroValue(t *testing.T) {
+    l := &RateLimiter{Default: Limit{Rate: 1, Burst: 1}}
+    l.SetLimit("free", Limit{})
+    ctx := context.Background()
+    if err := l.acquire(ctx, "a"); err != nil {
+        t.Fatal(err)
+    }
+    if err := l.acquire(ctx, "a"); CodeOf(err) != CodeResourceExhausted {
+        t.Fatalf("err = %v", err)
+    }
+    for i := 0; i < 3; i++ {
+        if err := l.acquire(ctx, "free"); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+func TestAdmissionZeroValue(t *testing.T) {
+    a := &Admission{Policy: AdmissionPolicy{
+        PerConn:     Limit{Rate: 1, Burst: 1},
+        PerIdentity: Limit{Rate: 1, Burst: 1},
+        PerService:  map[string]Limit{"echo": {Rate: 1, Burst: 1}},
+    }}
+    p := &Peer{ConnID: 1, Identity: "alice"}
+    if ok, _ := a.admit(p, "echo"); !ok {
+        t.Fatal("first request rejected")
+    }
+    if ok, _ := a.admit(p, "echo"); ok {
+        t.Fatal("second request admitted")
+    }
+    a.removeConn(1)
+}
+
+func TestAdmissionRejectDoesNotConsume(t *testing.T) {
+    a := NewAdmission(AdmissionPolicy{
+        PerConn:    Limit{Rate: 0.001, Burst: 2},
+        PerService: map[string]Limit{"hot": {Rate: 0.001, Burst: 1}},
+    })
+    p := &Peer{ConnID: 1}
+    if ok, _ := a.admit(p, "hot"); !ok {
+        t.Fatal("first hot request rejected")
+    }
+    // 被服务限速拒绝的请求不占用连接的限额
+    for i := 0; i < 5; i++ {
+        if ok, _ := a.admit(p, "hot"); ok {
+            t.Fatal("hot request admitted over the service limit")
+        }
+    }
+    if ok, _ := a.admit(p, "cold"); !ok {
+        t.Fatal("cold request rejected, connection tokens were consumed by rejected requests")
+    }
+    if n := a.Rejected(); n != 5 {
+        t.Fatalf("rejected = %d", n)
+    }
+}
+
+func TestClientRateLimit(t *testing.T) {
+    _, addr := startServer(t, nil)
+    c := newTestClient(t, addr)
+    c.RateLimit = NewRateLimiter()
+    c.RateLimit.SetLimit("echo", Limit{Rate: 20, Burst: 1})
+
+    ctx := context.Background()
+    if err := c.Call(ctx, "echo", &echoArgs{}, &echoArgs{}); err != nil {
+        t.Fatal(err)
+    }
+    if err := c.Call(ctx, "echo", &echoArgs{}, &echoArgs{}); CodeOf(err) != CodeResourceExhausted {
+        t.Fatalf("err = %v, want ResourceExhausted", err)
+    }
+    c.RateLimit.Wait = true
+    start := time.Now()
+    if err := c.Call(ctx, "echo", &echoArgs{}, &echoArgs{}); err != nil {
+        t.Fatal(err)
+    }
+    if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+        t.Fatalf("waiting call returned after %v", elapsed)
+    }
+}
+
+func TestServerAdmission(t *testing.T) {
+    _, addr := startServer(t, func(s *Server) {
+        s.Admission = NewAdmission(AdmissionPolicy{PerConn: Limit{Rate: 1, Burst: 2}})
+    })
+    c := newTestClient(t, addr)
+    ctx := context.Background()
+    for i := 0; i < 2; i++ {
+        if err := c.Call(ctx, "echo", &echoArgs{}, &echoArgs{}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    err := c.Call(ctx, "echo", &echoArgs{}, &echoArgs{})
+    if CodeOf(err) != CodeResourceExhausted {
+        t.Fatalf("err = %v, want ResourceExhausted", err)
+    }
+    if wait, ok := RetryAfter(err); !ok || wait <= 0 || wait > time.Second+time.Millisecond {
+        t.Fatalf("RetryAfter = %v %v", wait, ok)
+    }
+    // 另一个连接有自己的限额
+    if err := newTestClient(t, addr).Call(ctx, "echo", &echoArgs{}, &echoArgs{}); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestRejectReplyRetryAfter(t *testing.T) {
+    req := &pb.Message{Id: proto.Uint32(7)}
+    tests := []struct {
+        wait time.Duration
+        want string
+    }{
+        {0, ""},
+        {5 * time.Millisecond, "6"},
+        {time.Second, "1001"},
+    }
+    for _, tt := range tests {
+        reply := newRejectReply(req, Errorf(CodeUnavailable, "x"), tt.wait)
+        val, ok := dictGet(reply.GetDict(), MetadataRetryAfter)
+        if string(val) != tt.want || ok != (tt.want != "") {
+            t.Errorf("wait %v: retry-after = %q %v, want %q", tt.wait, val, ok, tt.want)
+        }
+        if _, ok := RetryAfter(replyError(reply)); ok != (tt.wait > 0) {
+            t.Errorf("wait %v: RetryAfter ok = %v", tt.wait, ok)
+        }
+    }
+}
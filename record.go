@@ -0,0 +1,182 @@
+package rpc
+
+import (
+    "encoding/json"
+    "github.com/DGHeroin/rpc/pb"
+    "io"
+    "os"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+const recordQueueSize = 1024
+
+type (
+    // RecordedCall 是录制的一次请求或通知, Payload 和 Reply 为 msgpack 编码的原始数据
+    RecordedCall struct {
+        Time     time.Time         `json:"time"`
+        Conn     uint64            `json:"conn"`
+        Id       uint32            `json:"id"`
+        Notify   bool              `json:"notify,omitempty"`
+        Service  string            `json:"service"`
+        Metadata map[string]string `json:"metadata,omitempty"`
+        Payload  []byte            `json:"payload,omitempty"`
+        Elapsed  time.Duration     `json:"elapsed"`
+        Code     Code              `json:"code"`
+        Error    string            `json:"error,omitempty"`
+        Reply    []byte            `json:"reply,omitempty"`
+    }
+    // Recorder 以 JSON Lines 格式录制服务端处理的请求, 被准入控制或过载保护拒绝的请求和 rpc. 开头的内部服务不录制
+    // Filter 不为空时只录制返回 true 的服务. 记录在回复发出后排队由单独的协程写入, 队列满时丢弃并计入 Dropped
+    Recorder struct {
+        Filter  func(service string) bool
+        mutex   sync.Mutex
+        enc     *json.Encoder
+        closer  io.Closer
+        err     error
+        qmutex  sync.Mutex
+        queue   chan *RecordedCall
+        done    chan struct{}
+        closed  bool
+        dropped uint64
+    }
+    // RecordReader 逐条读取 Recorder 写入的记录
+    RecordReader struct {
+        dec *json.Decoder
+    }
+)
+
+func NewRecorder(w io.Writer) *Recorder {
+    return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// CreateRecorder 创建录制文件, 文件已存在时追加
+func CreateRecorder(path string) (*Recorder, error) {
+    f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return nil, err
+    }
+    r := NewRecorder(f)
+    r.closer = f
+    return r, nil
+}
+
+// Err 返回第一次写入失败的错误, 写入失败后不再录制
+func (r *Recorder) Err() error {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    return r.err
+}
+
+// Dropped 返回因队列满而丢弃的记录数
+func (r *Recorder) Dropped() uint64 {
+    return atomic.LoadUint64(&r.dropped)
+}
+
+// Close 等待队列中的记录写完后关闭文件
+func (r *Recorder) Close() error {
+    r.qmutex.Lock()
+    if !r.closed && r.queue != nil {
+        close(r.queue)
+    }
+    r.closed = true
+    done := r.done
+    r.qmutex.Unlock()
+    if done != nil {
+        <-done
+    }
+
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    if r.closer == nil {
+        return nil
+    }
+    err := r.closer.Close()
+    r.closer = nil
+    if r.err == nil {
+        r.err = os.ErrClosed
+    }
+    return err
+}
+
+func (r *Recorder) Record(call *RecordedCall) error {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    if r.err != nil {
+        return r.err
+    }
+    if err := r.enc.Encode(call); err != nil {
+        r.err = err
+    }
+    return r.err
+}
+
+func (r *Recorder) record(connId uint64, req *pb.Message, reply *pb.Message, arrived time.Time, elapsed time.Duration) {
+    if r == nil || strings.HasPrefix(req.GetName(), "rpc.") || (r.Filter != nil && !r.Filter(req.GetName())) {
+        return
+    }
+    call := &RecordedCall{
+        Time:    arrived,
+        Conn:    connId,
+        Id:      req.GetId(),
+        Notify:  reply == nil,
+        Service: req.GetName(),
+        Payload: req.Payload,
+        Elapsed: elapsed,
+    }
+    if dict := req.GetDict(); dict != nil && len(dict.Values) > 0 {
+        call.Metadata = make(map[string]string, len(dict.Values))
+        for _, kv := range dict.Values {
+            call.Metadata[kv.GetKey()] = string(kv.Value)
+        }
+    }
+    if reply != nil {
+        if err := replyError(reply); err != nil {
+            call.Code = CodeOf(err)
+            call.Error = toError(err).Message
+        }
+        call.Reply = reply.Payload
+    }
+    r.enqueue(call)
+}
+
+// enqueue 不阻塞处理请求的协程, 写入跟不上时丢弃记录
+func (r *Recorder) enqueue(call *RecordedCall) {
+    r.qmutex.Lock()
+    defer r.qmutex.Unlock()
+    if r.closed {
+        return
+    }
+    if r.queue == nil {
+        r.queue = make(chan *RecordedCall, recordQueueSize)
+        r.done = make(chan struct{})
+        go r.writeLoop(r.queue, r.done)
+    }
+    select {
+    case r.queue <- call:
+    default:
+        atomic.AddUint64(&r.dropped, 1)
+    }
+}
+
+func (r *Recorder) writeLoop(queue chan *RecordedCall, done chan struct{}) {
+    defer close(done)
+    for call := range queue {
+        _ = r.Record(call)
+    }
+}
+
+func NewRecordReader(r io.Reader) *RecordReader {
+    return &RecordReader{dec: json.NewDecoder(r)}
+}
+
+// Next 返回下一条记录, 读完时返回 io.EOF
+func (r *RecordReader) Next() (*RecordedCall, error) {
+    call := &RecordedCall{}
+    if err := r.dec.Decode(call); err != nil {
+        return nil, err
+    }
+    return call, nil
+}
@@ -0,0 +1,158 @@
+package rpc
+
+import (
+    "bytes"
+    "context"
+    "io"
+    "sync"
+    "testing"
+    "time"
+)
+
+// blockingWriter 在 release 关闭前阻塞写入
+type blockingWriter struct {
+    release chan struct{}
+    mutex   sync.Mutex
+    buf     bytes.Buffer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+    <-w.release
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+    return w.buf.Write(p)
+}
+
+func readRecords(t *testing.T, r io.Reader) []*RecordedCall {
+    t.Helper()
+    reader := NewRecordReader(r)
+    var calls []*RecordedCall
+    for {
+        call, err := reader.Next()
+        if err == io.EOF {
+            return calls
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+        calls = append(calls, call)
+    }
+}
+
+func TestRecorderRecordsCalls(t *testing.T) {
+    var buf bytes.Buffer
+    rec := NewRecorder(&buf)
+    s, addr := startServer(t, func(s *Server) {
+        s.Recorder = rec
+        s.EnableHealth()
+        s.Register("fail", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+            return Errorf(CodeInvalidArgument, "bad %s", args.Name)
+        })
+    })
+    c := newTestClient(t, addr)
+    ctx := AppendMetadata(context.Background(), "tenant", "a")
+    if err := c.Call(ctx, "echo", &echoArgs{Name: "x", N: 1}, &echoArgs{}); err != nil {
+        t.Fatal(err)
+    }
+    _ = c.Call(ctx, "fail", &echoArgs{Name: "y"}, &echoArgs{})
+    // 内部服务不录制
+    if _, err := HealthCheck(ctx, c, ""); err != nil {
+        t.Fatal(err)
+    }
+    ctxShutdown, cancel := context.WithTimeout(context.Background(), time.Second)
+    defer cancel()
+    _ = s.Shutdown(ctxShutdown)
+    if err := rec.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    calls := readRecords(t, &buf)
+    if len(calls) != 2 {
+        t.Fatalf("recorded %d calls, want 2", len(calls))
+    }
+    if calls[0].Service != "echo" || calls[0].Metadata["tenant"] != "a" || calls[0].Code != CodeOK {
+        t.Errorf("first record = %+v", calls[0])
+    }
+    var args echoArgs
+    if err := Unmarshal(calls[0].Payload, &args); err != nil || args.Name != "x" {
+        t.Errorf("payload = %+v, %v", args, err)
+    }
+    if calls[1].Service != "fail" || calls[1].Code != CodeInvalidArgument || calls[1].Error != "bad y" {
+        t.Errorf("second record = %+v", calls[1])
+    }
+}
+
+func TestRecorderDropsOnOverflow(t *testing.T) {
+    w := &blockingWriter{release: make(chan struct{})}
+    rec := NewRecorder(w)
+    req, err := buildNotify("echo", &echoArgs{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    const extra = 10
+    // 写入协程取走一条后阻塞, 队列最多再容纳 recordQueueSize 条
+    for i := 0; i < recordQueueSize+1+extra; i++ {
+        rec.record(1, req, nil, time.Now(), 0)
+        if i == 0 {
+            eventually(t, time.Second, func() bool { return len(rec.queue) == 0 })
+        }
+    }
+    if rec.Dropped() != extra {
+        t.Fatalf("Dropped = %d, want %d", rec.Dropped(), extra)
+    }
+
+    close(w.release)
+    if err := rec.Close(); err != nil {
+        t.Fatal(err)
+    }
+    if n := len(readRecords(t, &w.buf)); n != recordQueueSize+1 {
+        t.Fatalf("flushed %d records, want %d", n, recordQueueSize+1)
+    }
+    // 关闭后的记录被忽略
+    rec.record(1, req, nil, time.Now(), 0)
+}
+
+func TestRecorderFilter(t *testing.T) {
+    var buf bytes.Buffer
+    rec := NewRecorder(&buf)
+    rec.Filter = func(service string) bool { return service == "keep" }
+    for _, name := range []string{"keep", "skip", "rpc.health.check", "keep"} {
+        req, err := buildNotify(name, &echoArgs{})
+        if err != nil {
+            t.Fatal(err)
+        }
+        rec.record(1, req, nil, time.Now(), 0)
+    }
+    if err := rec.Close(); err != nil {
+        t.Fatal(err)
+    }
+    calls := readRecords(t, &buf)
+    if len(calls) != 2 || !calls[0].Notify {
+        t.Fatalf("records = %+v", calls)
+    }
+}
+
+// 录制写入阻塞时回复照常发出
+func TestRecorderDoesNotDelayReplies(t *testing.T) {
+    w := &blockingWriter{release: make(chan struct{})}
+    rec := NewRecorder(w)
+    _, addr := startServer(t, func(s *Server) {
+        s.Recorder = rec
+    })
+    c := newTestClient(t, addr)
+    for i := 0; i < 3; i++ {
+        ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+        err := c.Call(ctx, "echo", &echoArgs{N: i}, &echoArgs{})
+        cancel()
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    close(w.release)
+    if err := rec.Close(); err != nil {
+        t.Fatal(err)
+    }
+    if n := len(readRecords(t, &w.buf)); n != 3 {
+        t.Fatalf("recorded %d calls, want 3", n)
+    }
+}
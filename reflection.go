@@ -0,0 +1,178 @@
+package rpc
+
+import (
+    "context"
+    "reflect"
+    "sort"
+    "strings"
+    "time"
+)
+
+const (
+    ServiceReflection = "rpc.reflection"
+)
+
+type (
+    // TypeSchema 描述参数或返回值按 msgpack 编码后的结构
+    // Kind 为 bool, int, uint, float, string, bytes, time, any, array, map, struct 之一,
+    // 递归引用的结构体只给出 Name 和 Ref
+    TypeSchema struct {
+        Kind   string        `msgpack:"kind" json:"kind"`
+        Name   string        `msgpack:"name,omitempty" json:"name,omitempty"`
+        Elem   *TypeSchema   `msgpack:"elem,omitempty" json:"elem,omitempty"`
+        Key    *TypeSchema   `msgpack:"key,omitempty" json:"key,omitempty"`
+        Fields []FieldSchema `msgpack:"fields,omitempty" json:"fields,omitempty"`
+        Ref    bool          `msgpack:"ref,omitempty" json:"ref,omitempty"`
+    }
+    FieldSchema struct {
+        Name      string      `msgpack:"name" json:"name"`
+        Type      *TypeSchema `msgpack:"type" json:"type"`
+        OmitEmpty bool        `msgpack:"omitempty,omitempty" json:"omitempty,omitempty"`
+    }
+    ServiceSchema struct {
+        Name    string      `msgpack:"name" json:"name"`
+        Request *TypeSchema `msgpack:"request" json:"request"`
+        Reply   *TypeSchema `msgpack:"reply" json:"reply"`
+    }
+    // ReflectionRequest 的 Services 为空时返回所有服务
+    ReflectionRequest struct {
+        Services []string `msgpack:"services,omitempty" json:"services,omitempty"`
+    }
+    ReflectionReply struct {
+        Services []ServiceSchema `msgpack:"services" json:"services"`
+    }
+)
+
+var (
+    typeOfTime       = reflect.TypeOf(time.Time{})
+    typeOfRawMessage = reflect.TypeOf(RawMessage(nil))
+)
+
+// EnableReflection 注册 rpc.reflection 服务, 返回除 rpc. 开头的内部服务以外的服务及其类型结构
+func (s *Server) EnableReflection() {
+    s.servant.Register(ServiceReflection, s.servant.handleReflection)
+}
+
+// ListServices 通过对端的 rpc.reflection 服务获取服务列表
+func ListServices(ctx context.Context, c Callable, services ...string) ([]ServiceSchema, error) {
+    reply := &ReflectionReply{}
+    if err := c.Call(ctx, ServiceReflection, &ReflectionRequest{Services: services}, reply); err != nil {
+        return nil, err
+    }
+    return reply.Services, nil
+}
+
+func (s *Servant) handleReflection(ctx context.Context, req *ReflectionRequest, reply *ReflectionReply) error {
+    wanted := make(map[string]bool, len(req.Services))
+    for _, name := range req.Services {
+        wanted[name] = true
+    }
+    for name, sh := range s.handler {
+        if strings.HasPrefix(name, "rpc.") {
+            continue
+        }
+        if len(wanted) > 0 && !wanted[name] {
+            continue
+        }
+        reply.Services = append(reply.Services, ServiceSchema{
+            Name:    name,
+            Request: SchemaOf(sh.r),
+            Reply:   SchemaOf(sh.w),
+        })
+    }
+    sort.Slice(reply.Services, func(i, j int) bool {
+        return reply.Services[i].Name < reply.Services[j].Name
+    })
+    return nil
+}
+
+// SchemaOf 返回类型按 msgpack 编码后的结构
+func SchemaOf(t reflect.Type) *TypeSchema {
+    return schemaOf(t, make(map[reflect.Type]bool))
+}
+
+func schemaOf(t reflect.Type, visiting map[reflect.Type]bool) *TypeSchema {
+    for t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+    switch {
+    case t == typeOfTime:
+        return &TypeSchema{Kind: "time"}
+    case t == typeOfRawMessage:
+        return &TypeSchema{Kind: "any"}
+    }
+    switch t.Kind() {
+    case reflect.Bool:
+        return &TypeSchema{Kind: "bool"}
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        return &TypeSchema{Kind: "int"}
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+        return &TypeSchema{Kind: "uint"}
+    case reflect.Float32, reflect.Float64:
+        return &TypeSchema{Kind: "float"}
+    case reflect.String:
+        return &TypeSchema{Kind: "string"}
+    case reflect.Slice, reflect.Array:
+        if t.Elem().Kind() == reflect.Uint8 {
+            return &TypeSchema{Kind: "bytes"}
+        }
+        return &TypeSchema{Kind: "array", Elem: schemaOf(t.Elem(), visiting)}
+    case reflect.Map:
+        return &TypeSchema{Kind: "map", Key: schemaOf(t.Key(), visiting), Elem: schemaOf(t.Elem(), visiting)}
+    case reflect.Struct:
+        if visiting[t] {
+            return &TypeSchema{Kind: "struct", Name: t.String(), Ref: true}
+        }
+        visiting[t] = true
+        defer delete(visiting, t)
+        return &TypeSchema{Kind: "struct", Name: t.String(), Fields: structFields(t, visiting)}
+    }
+    return &TypeSchema{Kind: "any"}
+}
+
+// structFields 按 msgpack 的规则列出字段: 使用 msgpack 标签的名字, 跳过未导出字段, 展开匿名结构体
+func structFields(t reflect.Type, visiting map[reflect.Type]bool) []FieldSchema {
+    var fields []FieldSchema
+    for i := 0; i < t.NumField(); i++ {
+        f := t.Field(i)
+        parts := strings.Split(f.Tag.Get("msgpack"), ",")
+        name, opts := parts[0], parts[1:]
+        if name == "-" || f.Name == "_msgpack" {
+            continue
+        }
+        if f.PkgPath != "" && !f.Anonymous {
+            continue
+        }
+        if f.Anonymous && name == "" && !hasOption(opts, "noinline") {
+            ft := f.Type
+            for ft.Kind() == reflect.Ptr {
+                ft = ft.Elem()
+            }
+            if ft.Kind() == reflect.Struct && ft != typeOfTime {
+                fields = append(fields, structFields(ft, visiting)...)
+                continue
+            }
+        }
+        if f.PkgPath != "" {
+            continue
+        }
+        if name == "" {
+            name = f.Name
+        }
+        fields = append(fields, FieldSchema{
+            Name:      name,
+            Type:      schemaOf(f.Type, visiting),
+            OmitEmpty: hasOption(opts, "omitempty"),
+        })
+    }
+    return fields
+}
+
+func hasOption(opts []string, opt string) bool {
+    for _, o := range opts {
+        if o == opt {
+            return true
+        }
+    }
+    return false
+}
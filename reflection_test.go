@@ -0,0 +1,116 @@
+package rpc
+
+import (
+    "context"
+    "reflect"
+    "testing"
+    "time"
+)
+
+type (
+    schemaBase struct {
+        ID uint32
+    }
+    schemaNode struct {
+        schemaBase
+        Name     string         `msgpack:"name,omitempty"`
+        Tags     map[string]int `msgpack:"tags"`
+        Children []*schemaNode  `msgpack:"children"`
+        Data     []byte         `msgpack:"data"`
+        At       time.Time      `msgpack:"at"`
+        Raw      RawMessage     `msgpack:"raw"`
+        Skip     string         `msgpack:"-"`
+        hidden   int
+        Scores   [2]float64        `msgpack:"scores"`
+        Extra    map[string]string `msgpack:"extra,omitempty"`
+    }
+)
+
+func TestSchemaOf(t *testing.T) {
+    tests := []struct {
+        v    interface{}
+        kind string
+    }{
+        {true, "bool"},
+        {int8(1), "int"},
+        {uint64(1), "uint"},
+        {1.5, "float"},
+        {"s", "string"},
+        {[]byte("b"), "bytes"},
+        {time.Time{}, "time"},
+        {RawMessage(nil), "any"},
+        {[]string{}, "array"},
+        {map[string]bool{}, "map"},
+        {&schemaBase{}, "struct"},
+    }
+    for _, tt := range tests {
+        if got := SchemaOf(reflect.TypeOf(tt.v)).Kind; got != tt.kind {
+            t.Errorf("SchemaOf(%T) = %s, want %s", tt.v, got, tt.kind)
+        }
+    }
+
+    schema := SchemaOf(reflect.TypeOf(&schemaNode{}))
+    want := []struct {
+        name      string
+        kind      string
+        omitEmpty bool
+    }{
+        {"ID", "uint", false},
+        {"name", "string", true},
+        {"tags", "map", false},
+        {"children", "array", false},
+        {"data", "bytes", false},
+        {"at", "time", false},
+        {"raw", "any", false},
+        {"scores", "array", false},
+        {"extra", "map", true},
+    }
+    if len(schema.Fields) != len(want) {
+        t.Fatalf("fields = %+v", schema.Fields)
+    }
+    for i, w := range want {
+        f := schema.Fields[i]
+        if f.Name != w.name || f.Type.Kind != w.kind || f.OmitEmpty != w.omitEmpty {
+            t.Errorf("field %d = %s %s %v, want %+v", i, f.Name, f.Type.Kind, f.OmitEmpty, w)
+        }
+    }
+    // 递归引用只给出名字
+    child := schema.Fields[3].Type.Elem
+    if !child.Ref || child.Name != "rpc.schemaNode" || len(child.Fields) != 0 {
+        t.Errorf("recursive field = %+v", child)
+    }
+}
+
+func TestReflectionService(t *testing.T) {
+    _, addr := startServer(t, func(s *Server) {
+        s.EnableReflection()
+        s.Register("node", func(ctx context.Context, args *schemaBase, reply *schemaNode) error {
+            return nil
+        })
+    })
+    c := newTestClient(t, addr)
+
+    services, err := ListServices(context.Background(), c)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var names []string
+    for _, s := range services {
+        names = append(names, s.Name)
+    }
+    // 内部服务不列出
+    if !reflect.DeepEqual(names, []string{"echo", "node"}) {
+        t.Fatalf("services = %v", names)
+    }
+
+    services, err = ListServices(context.Background(), c, "node")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(services) != 1 || services[0].Request.Name != "rpc.schemaBase" || services[0].Reply.Name != "rpc.schemaNode" {
+        t.Fatalf("node schema = %+v", services)
+    }
+    if got, want := services[0].Reply, SchemaOf(reflect.TypeOf(schemaNode{})); !reflect.DeepEqual(got, want) {
+        t.Fatalf("schema over the wire = %+v, want %+v", got, want)
+    }
+}
@@ -0,0 +1,388 @@
+package rpc
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+type (
+    // Resolver 持续推送完整的后端列表, ctx 结束后关闭返回的 channel
+    Resolver interface {
+        Watch(ctx context.Context) (<-chan []Endpoint, error)
+    }
+    // Registry 供服务端在启动时注册自身, 在 Shutdown 时注销
+    Registry interface {
+        Register(ctx context.Context, ep Endpoint) error
+        Deregister(ctx context.Context, ep Endpoint) error
+    }
+)
+
+// Watch 将 Resolver 推送的后端列表应用到客户端, 直到 ctx 结束
+func (c *BalancedClient) Watch(ctx context.Context, r Resolver) error {
+    updates, err := r.Watch(ctx)
+    if err != nil {
+        return err
+    }
+    go func() {
+        for endpoints := range updates {
+            c.SetEndpoints(endpoints)
+        }
+    }()
+    return nil
+}
+
+// StaticResolver 返回固定的后端列表
+type StaticResolver []Endpoint
+
+func (r StaticResolver) Watch(ctx context.Context) (<-chan []Endpoint, error) {
+    ch := make(chan []Endpoint, 1)
+    ch <- append([]Endpoint(nil), r...)
+    go func() {
+        <-ctx.Done()
+        close(ch)
+    }()
+    return ch, nil
+}
+
+// pollResolver 定期调用 resolve, 结果变化时推送
+func pollResolver(ctx context.Context, interval time.Duration, resolve func(ctx context.Context) ([]Endpoint, error)) <-chan []Endpoint {
+    ch := make(chan []Endpoint, 1)
+    go func() {
+        defer close(ch)
+        var last []Endpoint
+        first := true
+        for {
+            endpoints, err := resolve(ctx)
+            if err == nil && (first || !sameEndpoints(last, endpoints)) {
+                first = false
+                last = endpoints
+                select {
+                case ch <- endpoints:
+                case <-ctx.Done():
+                    return
+                }
+            }
+            select {
+            case <-time.After(interval):
+            case <-ctx.Done():
+                return
+            }
+        }
+    }()
+    return ch
+}
+
+func sameEndpoints(a, b []Endpoint) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+func sortEndpoints(endpoints []Endpoint) {
+    sort.Slice(endpoints, func(i, j int) bool {
+        return endpoints[i].Addr < endpoints[j].Addr
+    })
+}
+
+// FileResolver 监视 JSON 或 YAML 文件中的后端列表, 文件变化时推送
+//
+// JSON 格式为 [{"addr": "127.0.0.1:1600", "weight": 1}], 或者以 "endpoints" 为键的对象.
+// YAML 只支持同等结构的简单列表:
+//
+//   - addr: 127.0.0.1:1600
+//     weight: 2
+//   - 127.0.0.1:1601
+type FileResolver struct {
+    Path     string
+    Interval time.Duration
+}
+
+func (r *FileResolver) Watch(ctx context.Context) (<-chan []Endpoint, error) {
+    endpoints, err := readEndpointsFile(r.Path)
+    if err != nil {
+        return nil, err
+    }
+    interval := r.Interval
+    if interval <= 0 {
+        interval = time.Second
+    }
+    var (
+        lastMod  time.Time
+        lastSize int64 = -1
+    )
+    resolve := func(ctx context.Context) ([]Endpoint, error) {
+        info, err := os.Stat(r.Path)
+        if err != nil {
+            return nil, err
+        }
+        if info.ModTime().Equal(lastMod) && info.Size() == lastSize {
+            return endpoints, nil
+        }
+        result, err := readEndpointsFile(r.Path)
+        if err != nil {
+            return nil, err
+        }
+        lastMod, lastSize = info.ModTime(), info.Size()
+        endpoints = result
+        return endpoints, nil
+    }
+    return pollResolver(ctx, interval, resolve), nil
+}
+
+func readEndpointsFile(path string) ([]Endpoint, error) {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var endpoints []Endpoint
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".yaml", ".yml":
+        endpoints, err = parseEndpointsYAML(data)
+    default:
+        endpoints, err = parseEndpointsJSON(data)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+    sortEndpoints(endpoints)
+    return endpoints, nil
+}
+
+func parseEndpointsJSON(data []byte) ([]Endpoint, error) {
+    data = bytes.TrimSpace(data)
+    var endpoints []Endpoint
+    if len(data) > 0 && data[0] == '{' {
+        var doc struct {
+            Endpoints []Endpoint
+        }
+        if err := json.Unmarshal(data, &doc); err != nil {
+            return nil, err
+        }
+        endpoints = doc.Endpoints
+    } else if len(data) > 0 {
+        if err := json.Unmarshal(data, &endpoints); err != nil {
+            return nil, err
+        }
+    }
+    for i := range endpoints {
+        if endpoints[i].Addr == "" {
+            return nil, fmt.Errorf("endpoint %d: empty addr", i)
+        }
+    }
+    return endpoints, nil
+}
+
+func parseEndpointsYAML(data []byte) ([]Endpoint, error) {
+    var (
+        endpoints []Endpoint
+        current   *Endpoint
+        lineNo    int
+    )
+    scanner := bufio.NewScanner(bytes.NewReader(data))
+    for scanner.Scan() {
+        lineNo++
+        line := scanner.Text()
+        if i := strings.Index(line, "#"); i >= 0 {
+            line = line[:i]
+        }
+        line = strings.TrimSpace(line)
+        if line == "" || line == "endpoints:" || line == "---" {
+            continue
+        }
+        if strings.HasPrefix(line, "-") {
+            endpoints = append(endpoints, Endpoint{})
+            current = &endpoints[len(endpoints)-1]
+            line = strings.TrimSpace(line[1:])
+            if line == "" {
+                continue
+            }
+            if !strings.Contains(line, ": ") && !strings.HasSuffix(line, ":") {
+                current.Addr = yamlScalar(line)
+                continue
+            }
+        }
+        if current == nil {
+            return nil, fmt.Errorf("line %d: expected list item", lineNo)
+        }
+        kv := strings.SplitN(line, ":", 2)
+        if len(kv) != 2 {
+            return nil, fmt.Errorf("line %d: expected key: value", lineNo)
+        }
+        key, val := strings.TrimSpace(kv[0]), yamlScalar(kv[1])
+        switch strings.ToLower(key) {
+        case "addr":
+            current.Addr = val
+        case "weight":
+            n, err := strconv.Atoi(val)
+            if err != nil {
+                return nil, fmt.Errorf("line %d: bad weight %q", lineNo, val)
+            }
+            current.Weight = n
+        default:
+            return nil, fmt.Errorf("line %d: unknown key %q", lineNo, key)
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+    for i := range endpoints {
+        if endpoints[i].Addr == "" {
+            return nil, fmt.Errorf("endpoint %d: empty addr", i)
+        }
+    }
+    return endpoints, nil
+}
+
+// formatEndpointsYAML 输出 parseEndpointsYAML 能读取的列表
+func formatEndpointsYAML(endpoints []Endpoint) []byte {
+    var buf bytes.Buffer
+    for _, ep := range endpoints {
+        fmt.Fprintf(&buf, "- addr: %q\n", ep.Addr)
+        if ep.Weight != 0 {
+            fmt.Fprintf(&buf, "  weight: %d\n", ep.Weight)
+        }
+    }
+    return buf.Bytes()
+}
+
+func yamlScalar(s string) string {
+    s = strings.TrimSpace(s)
+    if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+        return s[1 : len(s)-1]
+    }
+    return s
+}
+
+// DNSResolver 通过 DNS 查询后端列表
+// Service 为空时查询 Host 的 A/AAAA 记录并使用 Port,
+// 否则查询 _Service._Proto.Host 的 SRV 记录, 权重取自 SRV 记录
+type DNSResolver struct {
+    Host     string
+    Port     int
+    Service  string
+    Proto    string
+    Interval time.Duration
+    Resolver *net.Resolver
+}
+
+func (r *DNSResolver) Watch(ctx context.Context) (<-chan []Endpoint, error) {
+    interval := r.Interval
+    if interval <= 0 {
+        interval = time.Second * 30
+    }
+    return pollResolver(ctx, interval, r.Resolve), nil
+}
+
+func (r *DNSResolver) Resolve(ctx context.Context) ([]Endpoint, error) {
+    resolver := r.Resolver
+    if resolver == nil {
+        resolver = net.DefaultResolver
+    }
+    var endpoints []Endpoint
+    if r.Service != "" {
+        proto := r.Proto
+        if proto == "" {
+            proto = "tcp"
+        }
+        _, records, err := resolver.LookupSRV(ctx, r.Service, proto, r.Host)
+        if err != nil {
+            return nil, err
+        }
+        for _, srv := range records {
+            endpoints = append(endpoints, Endpoint{
+                Addr:   net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))),
+                Weight: int(srv.Weight),
+            })
+        }
+    } else {
+        addrs, err := resolver.LookupHost(ctx, r.Host)
+        if err != nil {
+            return nil, err
+        }
+        for _, addr := range addrs {
+            endpoints = append(endpoints, Endpoint{
+                Addr:   net.JoinHostPort(addr, strconv.Itoa(r.Port)),
+                Weight: 1,
+            })
+        }
+    }
+    sortEndpoints(endpoints)
+    return endpoints, nil
+}
+
+// FileRegistry 将服务端地址写入 FileResolver 监视的文件, Path 以 .yaml 或 .yml 结尾时写入 YAML, 否则写入 JSON
+// 只在同一进程内串行化写入, 多进程共享文件时需要外部协调
+type FileRegistry struct {
+    Path  string
+    mutex sync.Mutex
+}
+
+func (r *FileRegistry) Register(ctx context.Context, ep Endpoint) error {
+    return r.update(func(endpoints []Endpoint) []Endpoint {
+        for i := range endpoints {
+            if endpoints[i].Addr == ep.Addr {
+                endpoints[i] = ep
+                return endpoints
+            }
+        }
+        return append(endpoints, ep)
+    })
+}
+
+func (r *FileRegistry) Deregister(ctx context.Context, ep Endpoint) error {
+    return r.update(func(endpoints []Endpoint) []Endpoint {
+        result := endpoints[:0]
+        for _, e := range endpoints {
+            if e.Addr != ep.Addr {
+                result = append(result, e)
+            }
+        }
+        return result
+    })
+}
+
+func (r *FileRegistry) update(fn func(endpoints []Endpoint) []Endpoint) error {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    endpoints, err := readEndpointsFile(r.Path)
+    if err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    endpoints = fn(endpoints)
+    sortEndpoints(endpoints)
+    var data []byte
+    switch strings.ToLower(filepath.Ext(r.Path)) {
+    case ".yaml", ".yml":
+        data = formatEndpointsYAML(endpoints)
+    default:
+        if endpoints == nil {
+            endpoints = []Endpoint{}
+        }
+        if data, err = json.MarshalIndent(endpoints, "", "  "); err != nil {
+            return err
+        }
+    }
+    tmp := r.Path + ".tmp"
+    if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, r.Path)
+}
@@ -0,0 +1,316 @@
+package rpc
+
+import (
+    "context"
+    "encoding/binary"
+    "io/ioutil"
+    "net"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestParseEndpointsYAML(t *testing.T) {
+    tests := []struct {
+        name    string
+        input   string
+        want    []Endpoint
+        wantErr bool
+    }{
+        {
+            name:  "plain list",
+            input: "- 127.0.0.1:1600\n- 127.0.0.1:1601\n",
+            want:  []Endpoint{{Addr: "127.0.0.1:1600"}, {Addr: "127.0.0.1:1601"}},
+        },
+        {
+            name: "mapping with header and comments",
+            input: `endpoints:
+  # primary
+  - addr: "127.0.0.1:1600"
+    weight: 2
+  - addr: '127.0.0.1:1601' # backup
+`,
+            want: []Endpoint{{Addr: "127.0.0.1:1600", Weight: 2}, {Addr: "127.0.0.1:1601"}},
+        },
+        {
+            name:  "dash on its own line",
+            input: "-\n  addr: a:1\n  weight: 3\n",
+            want:  []Endpoint{{Addr: "a:1", Weight: 3}},
+        },
+        {name: "empty", input: "---\n", want: nil},
+        {name: "bad weight", input: "- addr: a:1\n  weight: x\n", wantErr: true},
+        {name: "unknown key", input: "- addr: a:1\n  port: 1\n", wantErr: true},
+        {name: "key outside item", input: "addr: a:1\n", wantErr: true},
+        {name: "missing addr", input: "- weight: 1\n", wantErr: true},
+    }
+    for _, tt := range tests {
+        got, err := parseEndpointsYAML([]byte(tt.input))
+        if (err != nil) != tt.wantErr {
+            t.Errorf("%s: err = %v", tt.name, err)
+            continue
+        }
+        if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestFileRegistryAndResolver(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "endpoints.json")
+    registry := &FileRegistry{Path: path}
+    s, addr := startServer(t, func(s *Server) {
+        s.Registry = registry
+    })
+    eventually(t, time.Second, func() bool {
+        endpoints, err := readEndpointsFile(path)
+        return err == nil && len(endpoints) == 1 && endpoints[0].Addr == addr
+    })
+
+    c := newTestBalancedClient(t)
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    if err := c.Watch(ctx, &FileResolver{Path: path, Interval: 10 * time.Millisecond}); err != nil {
+        t.Fatal(err)
+    }
+    eventually(t, time.Second, func() bool { return len(c.Endpoints()) == 1 })
+    if err := c.Call(context.Background(), "echo", &echoArgs{}, &echoArgs{}); err != nil {
+        t.Fatal(err)
+    }
+
+    if err := s.Shutdown(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if string(data) != "[]" {
+        t.Fatalf("registry after shutdown = %s", data)
+    }
+    eventually(t, time.Second, func() bool { return len(c.Endpoints()) == 0 })
+}
+
+func TestFileRegistryYAML(t *testing.T) {
+    for _, name := range []string{"endpoints.yaml", "endpoints.yml"} {
+        path := filepath.Join(t.TempDir(), name)
+        registry := &FileRegistry{Path: path}
+        ctx := context.Background()
+        eps := []Endpoint{{Addr: "127.0.0.1:1601", Weight: 2}, {Addr: "127.0.0.1:1600"}}
+        for _, ep := range eps {
+            if err := registry.Register(ctx, ep); err != nil {
+                t.Fatal(err)
+            }
+        }
+        got, err := readEndpointsFile(path)
+        if err != nil {
+            t.Fatalf("%s: %v", name, err)
+        }
+        if want := []Endpoint{eps[1], eps[0]}; !reflect.DeepEqual(got, want) {
+            t.Fatalf("%s: got %v, want %v", name, got, want)
+        }
+        if err := registry.Deregister(ctx, eps[0]); err != nil {
+            t.Fatal(err)
+        }
+        if got, err = readEndpointsFile(path); err != nil || !reflect.DeepEqual(got, eps[1:]) {
+            t.Fatalf("%s: after deregister %v, %v", name, got, err)
+        }
+        if err := registry.Deregister(ctx, eps[1]); err != nil {
+            t.Fatal(err)
+        }
+        if got, err = readEndpointsFile(path); err != nil || len(got) != 0 {
+            t.Fatalf("%s: after deregister %v, %v", name, got, err)
+        }
+    }
+}
+
+// dnsStub 是只应答 A 和 SRV 查询的 UDP DNS 服务器
+type dnsStub struct {
+    conn net.PacketConn
+    a    map[string][]net.IP
+    srv  map[string][]net.SRV
+}
+
+func startDNSStub(t *testing.T, a map[string][]net.IP, srv map[string][]net.SRV) *net.Resolver {
+    t.Helper()
+    conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { _ = conn.Close() })
+    stub := &dnsStub{conn: conn, a: a, srv: srv}
+    go stub.serve()
+    return &net.Resolver{
+        PreferGo: true,
+        Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+            var d net.Dialer
+            return d.DialContext(ctx, "udp", conn.LocalAddr().String())
+        },
+    }
+}
+
+func (s *dnsStub) serve() {
+    buf := make([]byte, 1500)
+    for {
+        n, addr, err := s.conn.ReadFrom(buf)
+        if err != nil {
+            return
+        }
+        if resp := s.answer(buf[:n]); resp != nil {
+            _, _ = s.conn.WriteTo(resp, addr)
+        }
+    }
+}
+
+func (s *dnsStub) answer(req []byte) []byte {
+    if len(req) < 12 {
+        return nil
+    }
+    // 只解析第一个问题, 忽略 EDNS 等附加记录
+    name, end := readDNSName(req, 12)
+    if end < 0 || end+4 > len(req) {
+        return nil
+    }
+    qtype := binary.BigEndian.Uint16(req[end:])
+    question := req[12 : end+4]
+
+    var answers [][]byte
+    switch qtype {
+    case 1: // A
+        for _, ip := range s.a[name] {
+            answers = append(answers, dnsRecord(1, ip.To4()))
+        }
+    case 33: // SRV
+        for _, srv := range s.srv[name] {
+            rdata := make([]byte, 6)
+            binary.BigEndian.PutUint16(rdata[0:], srv.Priority)
+            binary.BigEndian.PutUint16(rdata[2:], srv.Weight)
+            binary.BigEndian.PutUint16(rdata[4:], srv.Port)
+            answers = append(answers, dnsRecord(33, append(rdata, encodeDNSName(srv.Target)...)))
+        }
+    }
+    resp := make([]byte, 12, 512)
+    copy(resp, req[:2])
+    binary.BigEndian.PutUint16(resp[2:], 0x8180)
+    binary.BigEndian.PutUint16(resp[4:], 1)
+    binary.BigEndian.PutUint16(resp[6:], uint16(len(answers)))
+    resp = append(resp, question...)
+    for _, rr := range answers {
+        resp = append(resp, rr...)
+    }
+    return resp
+}
+
+// dnsRecord 以指向问题中域名的压缩指针开头
+func dnsRecord(rrtype uint16, rdata []byte) []byte {
+    rr := []byte{0xc0, 12, 0, 0, 0, 1, 0, 0, 0, 60, 0, 0}
+    binary.BigEndian.PutUint16(rr[2:], rrtype)
+    binary.BigEndian.PutUint16(rr[10:], uint16(len(rdata)))
+    return append(rr, rdata...)
+}
+
+func readDNSName(msg []byte, off int) (string, int) {
+    var labels []string
+    for off < len(msg) {
+        n := int(msg[off])
+        off++
+        if n == 0 {
+            return strings.ToLower(strings.Join(labels, ".")) + ".", off
+        }
+        if off+n > len(msg) {
+            break
+        }
+        labels = append(labels, string(msg[off:off+n]))
+        off += n
+    }
+    return "", -1
+}
+
+func encodeDNSName(name string) []byte {
+    var b []byte
+    for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+        b = append(b, byte(len(label)))
+        b = append(b, label...)
+    }
+    return append(b, 0)
+}
+
+func TestDNSResolver(t *testing.T) {
+    resolver := startDNSStub(t,
+        map[string][]net.IP{
+            "backend.test.": {net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1")},
+        },
+        map[string][]net.SRV{
+            "_rpc._tcp.backend.test.": {
+                {Target: "b.backend.test.", Port: 1601, Priority: 10, Weight: 1},
+                {Target: "a.backend.test.", Port: 1600, Priority: 10, Weight: 3},
+            },
+        })
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    tests := []struct {
+        name string
+        r    *DNSResolver
+        want []Endpoint
+    }{
+        {
+            name: "A",
+            r:    &DNSResolver{Host: "backend.test.", Port: 1600, Resolver: resolver},
+            want: []Endpoint{{Addr: "10.0.0.1:1600", Weight: 1}, {Addr: "10.0.0.2:1600", Weight: 1}},
+        },
+        {
+            name: "SRV",
+            r:    &DNSResolver{Host: "backend.test.", Service: "rpc", Resolver: resolver},
+            want: []Endpoint{{Addr: "a.backend.test:1600", Weight: 3}, {Addr: "b.backend.test:1601", Weight: 1}},
+        },
+    }
+    for _, tt := range tests {
+        got, err := tt.r.Resolve(ctx)
+        if err != nil {
+            t.Fatalf("%s: %v", tt.name, err)
+        }
+        if !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+        }
+    }
+
+    if _, err := (&DNSResolver{Host: "missing.test.", Port: 1, Resolver: resolver}).Resolve(ctx); err == nil {
+        t.Error("resolved a name without records")
+    }
+
+    watchCtx, watchCancel := context.WithCancel(ctx)
+    updates, err := (&DNSResolver{Host: "backend.test.", Service: "rpc", Resolver: resolver}).Watch(watchCtx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if eps := <-updates; len(eps) != 2 || eps[0].Weight != 3 {
+        t.Fatalf("watch = %v", eps)
+    }
+    watchCancel()
+    for range updates {
+    }
+}
+
+func TestStaticResolver(t *testing.T) {
+    eps := StaticResolver{{Addr: "a:1", Weight: 2}, {Addr: "b:1"}}
+    ctx, cancel := context.WithCancel(context.Background())
+    updates, err := eps.Watch(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    got := <-updates
+    if !reflect.DeepEqual(got, []Endpoint(eps)) {
+        t.Fatalf("got %v", got)
+    }
+    // 推送的是副本
+    got[0].Addr = "changed"
+    if eps[0].Addr != "a:1" {
+        t.Fatal("resolver list was modified")
+    }
+    cancel()
+    if _, ok := <-updates; ok {
+        t.Fatal("channel not closed after ctx ended")
+    }
+}
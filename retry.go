@@ -0,0 +1,161 @@
+package rpc
+
+import (
+    "context"
+    "math/rand"
+    "strconv"
+    "sync"
+    "time"
+)
+
+const (
+    MetadataAttempt = "rpc-attempt"
+)
+
+type (
+    // RetryPolicy 描述调用失败后的重试方式
+    // 只有错误码可重试, 并且请求尚未写出或服务被标记为幂等时才会重试
+    RetryPolicy struct {
+        MaxAttempts    int
+        InitialBackoff time.Duration
+        MaxBackoff     time.Duration
+        Multiplier     float64
+        RetryableCodes []Code
+        Idempotent     map[string]bool
+        Budget         *RetryBudget
+    }
+    // RetryBudget 限制最近 10 秒内重试数占请求数的比例, 避免重试放大故障
+    RetryBudget struct {
+        Ratio        float64
+        MinPerSecond int
+        mutex        sync.Mutex
+        buckets      [retryBudgetWindow]retryBucket
+    }
+    retryBucket struct {
+        second   int64
+        requests int
+        retries  int
+    }
+)
+
+const retryBudgetWindow = 10
+
+func DefaultRetryPolicy() *RetryPolicy {
+    return &RetryPolicy{
+        MaxAttempts:    3,
+        InitialBackoff: time.Millisecond * 50,
+        MaxBackoff:     time.Second,
+        Multiplier:     2,
+        RetryableCodes: []Code{CodeUnavailable},
+        Budget:         NewRetryBudget(0.2, 10),
+    }
+}
+
+func NewRetryBudget(ratio float64, minPerSecond int) *RetryBudget {
+    return &RetryBudget{
+        Ratio:        ratio,
+        MinPerSecond: minPerSecond,
+    }
+}
+
+// AttemptOf 返回处理函数收到的请求是第几次尝试, 从 1 开始
+func AttemptOf(ctx context.Context) int {
+    n, err := strconv.Atoi(IncomingMetadata(ctx)[MetadataAttempt])
+    if err != nil || n <= 0 {
+        return 1
+    }
+    return n
+}
+
+func (p *RetryPolicy) wrap(call callFunc) callFunc {
+    return func(ctx context.Context, service string, args interface{}, reply interface{}) error {
+        if p.Budget != nil {
+            p.Budget.request()
+        }
+        var err error
+        for attempt := 1; ; attempt++ {
+            attemptCtx := AppendMetadata(ctx, MetadataAttempt, strconv.Itoa(attempt))
+            err = call(attemptCtx, service, args, reply)
+            if err == nil || attempt >= p.MaxAttempts || !p.shouldRetry(service, err) {
+                return err
+            }
+            if p.Budget != nil && !p.Budget.withdraw() {
+                return err
+            }
+            select {
+            case <-time.After(p.backoff(attempt)):
+            case <-ctx.Done():
+                return err
+            }
+        }
+    }
+}
+
+func (p *RetryPolicy) shouldRetry(service string, err error) bool {
+    code := CodeOf(err)
+    retryable := false
+    for _, c := range p.RetryableCodes {
+        if c == code {
+            retryable = true
+            break
+        }
+    }
+    if !retryable {
+        return false
+    }
+    return isUnsent(err) || p.Idempotent[service]
+}
+
+// backoff 返回第 attempt 次失败后的等待时间, 使用全抖动的指数退避
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+    d := float64(p.InitialBackoff)
+    multiplier := p.Multiplier
+    if multiplier < 1 {
+        multiplier = 1
+    }
+    for i := 1; i < attempt; i++ {
+        d *= multiplier
+        if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+            d = float64(p.MaxBackoff)
+            break
+        }
+    }
+    if d <= 0 {
+        return 0
+    }
+    return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+func (b *RetryBudget) bucket(now time.Time) *retryBucket {
+    sec := now.Unix()
+    bk := &b.buckets[sec%retryBudgetWindow]
+    if bk.second != sec {
+        *bk = retryBucket{second: sec}
+    }
+    return bk
+}
+
+func (b *RetryBudget) request() {
+    b.mutex.Lock()
+    b.bucket(time.Now()).requests++
+    b.mutex.Unlock()
+}
+
+func (b *RetryBudget) withdraw() bool {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    now := time.Now()
+    var requests, retries int
+    for i := range b.buckets {
+        if now.Unix()-b.buckets[i].second < retryBudgetWindow {
+            requests += b.buckets[i].requests
+            retries += b.buckets[i].retries
+        }
+    }
+    allowed := b.Ratio*float64(requests) + float64(b.MinPerSecond*retryBudgetWindow)
+    if float64(retries) >= allowed {
+        return false
+    }
+    b.bucket(now).retries++
+    return true
+}
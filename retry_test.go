@@ -0,0 +1,110 @@
+package rpc
+
+import (
+    "context"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestRetryShouldRetry(t *testing.T) {
+    p := &RetryPolicy{
+        RetryableCodes: []Code{CodeUnavailable, CodeResourceExhausted},
+        Idempotent:     map[string]bool{"get": true},
+    }
+    tests := []struct {
+        service string
+        err     error
+        want    bool
+    }{
+        {"get", Errorf(CodeUnavailable, "down"), true},
+        {"set", Errorf(CodeUnavailable, "down"), false},
+        {"set", unsentErrorf(CodeUnavailable, "down"), true},
+        {"set", connErrorf(true, "dial failed"), true},
+        {"set", connErrorf(false, "connection closed"), false},
+        {"get", Errorf(CodeResourceExhausted, "busy"), true},
+        {"get", Errorf(CodeInvalidArgument, "bad"), false},
+        {"get", unsentErrorf(CodeInternal, "bad"), false},
+        {"get", context.DeadlineExceeded, false},
+    }
+    for _, tt := range tests {
+        if got := p.shouldRetry(tt.service, tt.err); got != tt.want {
+            t.Errorf("shouldRetry(%s, %v) = %v, want %v", tt.service, tt.err, got, tt.want)
+        }
+    }
+}
+
+func TestRetryBackoff(t *testing.T) {
+    p := &RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 40 * time.Millisecond, Multiplier: 2}
+    tests := []struct {
+        attempt int
+        max     time.Duration
+    }{
+        {1, 10 * time.Millisecond},
+        {2, 20 * time.Millisecond},
+        {3, 40 * time.Millisecond},
+        {10, 40 * time.Millisecond},
+    }
+    for _, tt := range tests {
+        for i := 0; i < 100; i++ {
+            if d := p.backoff(tt.attempt); d <= 0 || d > tt.max {
+                t.Fatalf("backoff(%d) = %v, want (0, %v]", tt.attempt, d, tt.max)
+            }
+        }
+    }
+}
+
+func TestRetryBudget(t *testing.T) {
+    b := NewRetryBudget(0.5, 0)
+    for i := 0; i < 4; i++ {
+        b.request()
+    }
+    for i := 0; i < 2; i++ {
+        if !b.withdraw() {
+            t.Fatalf("retry %d rejected", i)
+        }
+    }
+    if b.withdraw() {
+        t.Fatal("retry over budget allowed")
+    }
+}
+
+func TestRetryCall(t *testing.T) {
+    var calls int32
+    flaky := func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+        atomic.AddInt32(&calls, 1)
+        if n := AttemptOf(ctx); n < 3 {
+            return Errorf(CodeUnavailable, "attempt %d", n)
+        }
+        reply.N = AttemptOf(ctx)
+        return nil
+    }
+    _, addr := startServer(t, func(s *Server) {
+        s.Register("get", flaky)
+        s.Register("set", flaky)
+    })
+    c := newTestClient(t, addr)
+    c.Retry = &RetryPolicy{
+        MaxAttempts:    3,
+        InitialBackoff: time.Millisecond,
+        RetryableCodes: []Code{CodeUnavailable},
+        Idempotent:     map[string]bool{"get": true},
+    }
+
+    var reply echoArgs
+    if err := c.Call(context.Background(), "get", &echoArgs{}, &reply); err != nil || reply.N != 3 {
+        t.Fatalf("idempotent call: %v %v", err, reply)
+    }
+    if n := atomic.LoadInt32(&calls); n != 3 {
+        t.Fatalf("idempotent call ran %d times", n)
+    }
+
+    // 已经发出的非幂等调用不重试
+    atomic.StoreInt32(&calls, 0)
+    if err := c.Call(context.Background(), "set", &echoArgs{}, &reply); CodeOf(err) != CodeUnavailable {
+        t.Fatalf("non-idempotent call: %v", err)
+    }
+    if n := atomic.LoadInt32(&calls); n != 1 {
+        t.Fatalf("non-idempotent call ran %d times", n)
+    }
+}
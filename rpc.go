@@ -8,7 +8,6 @@ import (
     "google.golang.org/protobuf/proto"
     "hash/crc32"
     "io"
-    "log"
     "net"
     "reflect"
     "runtime"
@@ -16,7 +15,8 @@ import (
 )
 
 const (
-    HeaderSize = 14
+    HeaderSize       = 14
+    defaultStackSize = 2048
 )
 
 var (
@@ -26,6 +26,7 @@ var (
     ErrHeaderType     = fmt.Errorf("unknow header type")
     ErrHandleNotFound = fmt.Errorf("handler not found")
     ErrBadData        = fmt.Errorf("bad data")
+    ErrServerClosed   = fmt.Errorf("server closed")
 )
 
 func readHeader(conn net.Conn, duration time.Duration) ([HeaderSize]byte, error) {
@@ -141,6 +142,64 @@ func buildRequest(reqId uint32, service string, payload interface{}) (*pb.Messag
     return req, nil
 }
 
+func buildNotify(service string, payload interface{}) (*pb.Message, error) {
+    req := &pb.Message{}
+    req.Action = proto.Int32(3)
+    req.Name = proto.String(service)
+    if data, err := Marshal(payload); err != nil {
+        return nil, err
+    } else {
+        req.Payload = data
+    }
+
+    return req, nil
+}
+
+func dictGet(dict *pb.Dict, key string) ([]byte, bool) {
+    if dict == nil {
+        return nil, false
+    }
+    for _, kv := range dict.Values {
+        if kv.GetKey() == key {
+            return kv.Value, true
+        }
+    }
+    return nil, false
+}
+
+func dictSet(msg *pb.Message, key string, value []byte) {
+    if msg.Dict == nil {
+        msg.Dict = &pb.Dict{}
+    }
+    for _, kv := range msg.Dict.Values {
+        if kv.GetKey() == key {
+            kv.Value = value
+            return
+        }
+    }
+    msg.Dict.Values = append(msg.Dict.Values, &pb.KeyValue{
+        Key:   proto.String(key),
+        Value: value,
+    })
+}
+
+// panicStack 返回当前调用栈, size <= 0 时不截断
+func panicStack(size int) []byte {
+    if size > 0 {
+        buf := make([]byte, size)
+        n := runtime.Stack(buf, false)
+        return buf[:n]
+    }
+    buf := make([]byte, 4096)
+    for {
+        n := runtime.Stack(buf, false)
+        if n < len(buf) {
+            return buf[:n]
+        }
+        buf = make([]byte, len(buf)*2)
+    }
+}
+
 func makePkt(typeCode byte, msg *pb.Message) ([]byte, error) {
     var (
         payload []byte
@@ -168,14 +227,9 @@ func makePkt(typeCode byte, msg *pb.Message) ([]byte, error) {
 
 func checkFunc(fn interface{}) (sh *ServantHandle, ok bool) {
     defer func() {
+        // fn 不是函数时 reflect 会 panic, 由调用方按注册失败处理
         if e := recover(); e != nil {
-            buffer := bytes.NewBufferString(fmt.Sprint(e))
-            //打印调用栈信息
-            buf := make([]byte, 2048)
-            n := runtime.Stack(buf, false)
-            stackInfo := fmt.Sprintf("\n%s", buf[:n])
-            buffer.WriteString(fmt.Sprintf("panic stack info %s", stackInfo))
-            log.Println(buffer)
+            ok = false
         }
     }()
     // 检查传入的函数是否符合格式要求
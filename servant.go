@@ -1,66 +1,105 @@
 package rpc
 
 import (
-    "bytes"
     "context"
     "fmt"
     "github.com/DGHeroin/rpc/pb"
     "google.golang.org/protobuf/proto"
-    "log"
     "reflect"
-    "runtime"
+    "sync/atomic"
+    "time"
 )
 
 type (
     Servant struct {
-        handler map[string]*ServantHandle
+        handler   map[string]*ServantHandle
+        StackSize int
+        onPanic   func(service string, e interface{}, stack []byte)
+        panics    uint64
+        tracing   *Tracing
+        metrics   Metrics
+        log       Logger
+        accessLog bool
     }
     ServantHandle struct {
-        fn reflect.Value
-        r  reflect.Type
-        w  reflect.Type
+        fn     reflect.Value
+        r      reflect.Type
+        w      reflect.Type
+        calls  uint64
+        errors uint64
     }
 )
 
 func NewServant() *Servant {
     return &Servant{
-        handler: make(map[string]*ServantHandle),
+        handler:   make(map[string]*ServantHandle),
+        StackSize: defaultStackSize,
     }
 }
 
 func (s *Servant) Register(serviceName string, i interface{}) bool {
     sh, ok := checkFunc(i)
     if !ok {
-        log.Println("注册失败", serviceName)
+        s.logger().Error("invalid handler", "service", serviceName, "type", fmt.Sprintf("%T", i))
         return false
     }
     s.handler[serviceName] = sh
     return true
 }
 
-func (s *Servant) handleFunc(req *pb.Message) (reply *pb.Message) {
+// OnPanic 设置处理函数 panic 时的回调, stack 按 StackSize 截断
+func (s *Servant) OnPanic(fn func(service string, e interface{}, stack []byte)) {
+    s.onPanic = fn
+}
+
+// PanicCount 返回处理函数 panic 的累计次数
+func (s *Servant) PanicCount() uint64 {
+    return atomic.LoadUint64(&s.panics)
+}
+
+func (s *Servant) handleFunc(ctx context.Context, req *pb.Message) (reply *pb.Message) {
     reply = &pb.Message{}
+    reply.Id = proto.Uint32(req.GetId())
 
+    ctx = newIncomingContext(ctx, req)
+    ctx, span := s.tracing.startServer(ctx, req.GetName(), len(req.Payload))
+    finish := startCall(s.metrics, SideServer, req.GetName())
+    start := time.Now()
+    sh, ok := s.handler[req.GetName()]
+    defer func() {
+        err := replyError(reply)
+        finish(err)
+        if sh != nil {
+            atomic.AddUint64(&sh.calls, 1)
+            if err != nil {
+                atomic.AddUint64(&sh.errors, 1)
+            }
+        }
+        if s.accessLog {
+            s.logAccess(ctx, req, err, time.Since(start))
+        }
+        span.SetAttributes(attrResponseSize.Int(len(reply.Payload)))
+        endSpan(span, err)
+    }()
     defer func() {
         if e := recover(); e != nil {
-            buffer := bytes.NewBufferString(fmt.Sprint(e))
-            //打印调用栈信息
-            buf := make([]byte, 2048)
-            n := runtime.Stack(buf, false)
-            stackInfo := fmt.Sprintf("\n%s", buf[:n])
-            buffer.WriteString(fmt.Sprintf("panic stack info %s", stackInfo))
-            log.Println(buffer)
-            //replyValues["error"] = []byte(fmt.Sprint(e))
+            atomic.AddUint64(&s.panics, 1)
+            stack := panicStack(s.StackSize)
+            s.logger().Error("handler panic", "service", req.GetName(), "request_id", req.GetId(),
+                "panic", fmt.Sprint(e), "stack", string(stack))
+            if s.onPanic != nil {
+                s.onPanic(req.GetName(), e, stack)
+            }
+            reply.Payload = nil
+            setReplyError(reply, Errorf(CodeInternal, "panic: %v", e))
         }
     }()
-    sh, ok := s.handler[*req.Name]
     if !ok {
-        log.Println("找不到函数")
-        reply.Error = proto.String(ErrHandleNotFound.Error())
+        s.logger().Warn("service not found", "service", req.GetName(), "request_id", req.GetId())
+        setReplyError(reply, Errorf(CodeNotFound, "%v: %s", ErrHandleNotFound, req.GetName()))
         return
     }
 
-    ctx := context.Background()
     t0 := reflect.ValueOf(ctx)
     t1 := reflect.New(sh.r)
     t2 := reflect.New(sh.w)
@@ -68,22 +107,21 @@ func (s *Servant) handleFunc(req *pb.Message) (reply *pb.Message) {
         t0, t1, t2,
     }
 
-    reply.Id = proto.Uint32(*req.Id)
     err := Unmarshal(req.Payload, t1.Interface())
     if err != nil {
-        reply.Error = proto.String(ErrHandleNotFound.Error())
+        setReplyError(reply, Errorf(CodeInvalidArgument, "%v: %v", ErrBadData, err))
         return
     }
 
     rs := sh.fn.Call(in)
     r1 := rs[0]
     if r1.Interface() != nil {
-        reply.Error = proto.String(fmt.Sprint(r1.Interface()))
+        setReplyError(reply, r1.Interface().(error))
         return
     }
     data, err := Marshal(in[2].Interface())
     if err != nil {
-        reply.Error = proto.String(fmt.Sprint(r1.Interface()))
+        setReplyError(reply, Errorf(CodeInternal, "%v", err))
         return
     }
 
@@ -91,9 +129,13 @@ func (s *Servant) handleFunc(req *pb.Message) (reply *pb.Message) {
     return
 }
 
-func (s *Servant) handleRequest(msg *pb.Message) *pb.Message {
-    reply := s.handleFunc(msg)
+func (s *Servant) handleRequest(ctx context.Context, msg *pb.Message) *pb.Message {
+    reply := s.handleFunc(ctx, msg)
 
     reply.Action = proto.Int32(2)
     return reply
 }
+
+func (s *Servant) handleNotify(ctx context.Context, msg *pb.Message) {
+    _ = s.handleFunc(ctx, msg)
+}
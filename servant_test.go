@@ -0,0 +1,65 @@
+package rpc
+
+import (
+    "context"
+    "testing"
+)
+
+func TestHandlerPanicReturnsInternal(t *testing.T) {
+    var hooked string
+    s, addr := startServer(t, func(s *Server) {
+        s.Register("boom", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+            panic("boom")
+        })
+        s.OnPanic(func(service string, e interface{}, stack []byte) {
+            hooked = service
+        })
+        s.SetPanicStackSize(64)
+    })
+    c := newTestClient(t, addr)
+
+    var reply echoArgs
+    err := c.Call(context.Background(), "boom", &echoArgs{}, &reply)
+    if CodeOf(err) != CodeInternal {
+        t.Fatalf("err = %v, want Internal", err)
+    }
+    if hooked != "boom" || s.PanicCount() != 1 {
+        t.Fatalf("hook = %q, count = %d", hooked, s.PanicCount())
+    }
+    // 连接保持可用
+    if err := c.Call(context.Background(), "echo", &echoArgs{N: 1}, &reply); err != nil || reply.N != 1 {
+        t.Fatalf("echo after panic: %v %v", err, reply)
+    }
+}
+
+func TestHandlerErrors(t *testing.T) {
+    _, addr := startServer(t, func(s *Server) {
+        s.Register("fail", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+            return Errorf(CodePermissionDenied, "no")
+        })
+    })
+    c := newTestClient(t, addr)
+    tests := []struct {
+        service string
+        code    Code
+    }{
+        {"echo", CodeOK},
+        {"fail", CodePermissionDenied},
+        {"missing", CodeNotFound},
+    }
+    for _, tt := range tests {
+        var reply echoArgs
+        if err := c.Call(context.Background(), tt.service, &echoArgs{}, &reply); CodeOf(err) != tt.code {
+            t.Errorf("%s: err = %v, want %s", tt.service, err, tt.code)
+        }
+    }
+}
+
+func TestPanicStack(t *testing.T) {
+    if n := len(panicStack(32)); n > 32 {
+        t.Fatalf("truncated stack is %d bytes", n)
+    }
+    if n := len(panicStack(0)); n <= 32 {
+        t.Fatalf("full stack is %d bytes", n)
+    }
+}
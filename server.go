@@ -2,6 +2,7 @@ package rpc
 
 import (
     "context"
+    "crypto/tls"
     "github.com/DGHeroin/rpc/pb"
     "net"
     "sync"
@@ -10,10 +11,23 @@ import (
 
 type Server struct {
     ReadWriteTimeout time.Duration
+    Broker           *Broker
+    Registry         Registry
+    Advertise        Endpoint
+    Admission        *Admission
+    Shedder          *LoadShedder
+    Recorder         *Recorder
+    health           *HealthServer
     servant          *Servant
     onOpen           func(invokable Callable)
     onClose          func(invokable Callable)
     waitGroup        sync.WaitGroup
+    connMutex        sync.RWMutex
+    conns            map[uint64]*acceptClient
+    groups           map[string]map[uint64]*acceptClient
+    listeners        map[net.Listener]Endpoint
+    requests         sync.WaitGroup
+    closed           bool
 }
 
 func (s *Server) ListenAndServe(addr string) error {
@@ -21,48 +35,246 @@ func (s *Server) ListenAndServe(addr string) error {
     if err != nil {
         return err
     }
+    return s.Serve(ln)
+}
+
+func (s *Server) ListenAndServeTLS(addr string, config *tls.Config) error {
+    ln, err := tls.Listen("tcp", addr, config)
+    if err != nil {
+        return err
+    }
+    return s.Serve(ln)
+}
+
+func (s *Server) Serve(ln net.Listener) error {
+    ep := s.Advertise
+    if ep.Addr == "" {
+        ep.Addr = ln.Addr().String()
+    }
+    s.connMutex.Lock()
+    if s.closed {
+        s.connMutex.Unlock()
+        _ = ln.Close()
+        return ErrServerClosed
+    }
+    s.listeners[ln] = ep
+    s.connMutex.Unlock()
+    if s.Registry != nil {
+        if err := s.Registry.Register(context.Background(), ep); err != nil {
+            s.connMutex.Lock()
+            delete(s.listeners, ln)
+            s.connMutex.Unlock()
+            _ = ln.Close()
+            return err
+        }
+    }
+
     for {
         conn, err := ln.Accept()
         if err != nil {
-            break
+            s.connMutex.RLock()
+            closed := s.closed
+            s.connMutex.RUnlock()
+            if closed {
+                return ErrServerClosed
+            }
+            return err
         }
-        s.handleConn(conn)
+        go s.handleConn(conn)
+    }
+}
+
+// Shutdown 从 Registry 注销并停止监听, 等待处理中的请求写出回复后关闭所有连接, ctx 结束时不再等待
+func (s *Server) Shutdown(ctx context.Context) error {
+    s.health.Shutdown()
+    s.connMutex.Lock()
+    s.closed = true
+    listeners := s.listeners
+    s.listeners = make(map[net.Listener]Endpoint)
+    conns := make([]*acceptClient, 0, len(s.conns))
+    for _, cli := range s.conns {
+        conns = append(conns, cli)
+    }
+    s.connMutex.Unlock()
+
+    var firstErr error
+    for ln, ep := range listeners {
+        if s.Registry != nil {
+            if err := s.Registry.Deregister(ctx, ep); err != nil && firstErr == nil {
+                firstErr = err
+            }
+        }
+        _ = ln.Close()
+    }
+
+    err := waitDone(ctx, &s.requests)
+    for _, cli := range conns {
+        if err != nil {
+            cli.conn.Close()
+        } else {
+            cli.conn.closeAfterPush()
+        }
+    }
+    if err == nil {
+        if err = waitDone(ctx, &s.waitGroup); err != nil {
+            for _, cli := range conns {
+                cli.conn.Close()
+            }
+        }
+    }
+    if firstErr == nil {
+        firstErr = err
+    }
+    return firstErr
+}
+
+// serve 异步处理一个请求, 服务器关闭后返回 false
+func (s *Server) serve(fn func()) bool {
+    s.connMutex.RLock()
+    defer s.connMutex.RUnlock()
+    if s.closed {
+        return false
+    }
+    asyncDo(fn, &s.requests)
+    return true
+}
+
+func waitDone(ctx context.Context, wg *sync.WaitGroup) error {
+    done := make(chan struct{})
+    go func() {
+        wg.Wait()
+        close(done)
+    }()
+    select {
+    case <-done:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
     }
-    return nil
 }
 
 type acceptClient struct {
-    conn *Conn
-    mgr  *CallManager
+    conn    *Conn
+    mgr     *CallManager
+    peer    *Peer
+    servant *Servant
 }
 
 func (c *acceptClient) Call(ctx context.Context, service string, args interface{}, reply interface{}) error {
+    ctx, span := c.servant.tracing.startClient(ctx, service, c.peer.RemoteAddr.String())
+    finish := startCall(c.servant.metrics, SideClient, service)
     call := c.mgr.newCall(c.conn)
-    defer func() {
-        c.mgr.remCall(call)
-        close(call.done)
-    }()
-    return call.Call(ctx, service, args, reply)
+    defer c.mgr.remCall(call)
+    err := call.Call(ctx, service, args, reply)
+    finish(err)
+    endCall(span, call, err)
+    return err
+}
+
+func (c *acceptClient) Notify(service string, args interface{}) error {
+    msg, err := buildNotify(service, args)
+    if err != nil {
+        return err
+    }
+    return c.conn.Send(3, msg)
+}
+
+func (c *acceptClient) Peer() *Peer {
+    return c.peer
 }
+
 func (s *Server) handleConn(conn net.Conn) {
+    if tlsConn, ok := conn.(*tls.Conn); ok {
+        if err := tlsConn.Handshake(); err != nil {
+            _ = conn.Close()
+            return
+        }
+    }
     c := NewConn(conn, &s.waitGroup)
+    c.instrument(s.servant.metrics, SideServer)
+    cli := &acceptClient{
+        conn:    c,
+        mgr:     newCallManager(),
+        servant: s.servant,
+    }
+    cli.peer = newPeer(c, cli)
     c.OnMessage = func(msgType byte, msg *pb.Message) {
         switch msgType {
-        case 1:
-            reply := s.servant.handleRequest(msg)
-            _ = c.Send(2, reply)
+        case 1: // request
+            if s.Admission != nil {
+                if ok, wait := s.Admission.admit(cli.peer, msg.GetName()); !ok {
+                    err := Errorf(CodeResourceExhausted, "request rejected by admission control: %s", msg.GetName())
+                    _ = c.Send(2, newRejectReply(msg, err, wait))
+                    return
+                }
+            }
+            arrived := time.Now()
+            ctx, done := cli.peer.requests.start(newPeerContext(context.Background(), cli.peer), msg)
+            served := s.serve(func() {
+                defer done()
+                if s.Shedder != nil {
+                    release, ok := s.Shedder.acquire(ctx, arrived, requestPriority(msg))
+                    if !ok {
+                        err := Errorf(CodeResourceExhausted, "server overloaded: %s", msg.GetName())
+                        _ = c.Send(2, newRejectReply(msg, err, s.Shedder.Interval))
+                        return
+                    }
+                    defer release()
+                }
+                start := time.Now()
+                reply := s.servant.handleRequest(ctx, msg)
+                elapsed := time.Since(start)
+                _ = c.Send(2, reply)
+                s.Recorder.record(cli.peer.ConnID, msg, reply, arrived, elapsed)
+            })
+            if !served {
+                done()
+                err := Errorf(CodeUnavailable, "server is shutting down: %s", msg.GetName())
+                _ = c.Send(2, newRejectReply(msg, err, 0))
+            }
+        case 2: // response
+            if call := cli.mgr.popCall(msg.GetId()); call != nil {
+                call.done <- msg
+            }
+        case 3: // notify
+            if s.Admission != nil {
+                if ok, _ := s.Admission.admit(cli.peer, msg.GetName()); !ok {
+                    return
+                }
+            }
+            arrived := time.Now()
+            s.serve(func() {
+                ctx := newPeerContext(context.Background(), cli.peer)
+                s.servant.handleNotify(ctx, msg)
+                s.Recorder.record(cli.peer.ConnID, msg, nil, arrived, time.Since(arrived))
+            })
+        case 4: // cancel
+            cli.peer.requests.cancel(msg.GetId())
         }
     }
-
-    cli := &acceptClient{
-        conn: c,
-        mgr:  newCallManager(),
-    }
     c.OnClose = func(conn *Conn) {
-        s.onClose(cli)
+        s.servant.logger().Debug("connection closed", "conn_id", cli.peer.ConnID, "peer", cli.peer.RemoteAddr)
+        s.removeConn(cli)
+        cli.mgr.failConn(conn)
+        cli.peer.requests.cancelAll()
+        s.Admission.removeConn(cli.peer.ConnID)
+        s.Broker.removeConn(cli.peer.ConnID)
+        s.health.removeConn(cli.peer.ConnID)
+        if s.onClose != nil {
+            s.onClose(cli)
+        }
+        cli.peer.Session.Clear()
+    }
+    if !s.addConn(cli) {
+        _ = conn.Close()
+        return
+    }
+    s.servant.logger().Debug("connection opened", "conn_id", cli.peer.ConnID, "peer", cli.peer.RemoteAddr)
+    if s.onOpen != nil {
+        s.onOpen(cli)
     }
-    s.onOpen(cli)
     c.Do()
+    s.waitGroup.Done()
 }
 
 func (s *Server) OnOpen(fn func(caller Callable)) {
@@ -76,3 +288,16 @@ func (s *Server) OnClose(fn func(caller Callable)) {
 func (s *Server) Register(serviceName string, i interface{}) bool {
     return s.servant.Register(serviceName, i)
 }
+
+func (s *Server) OnPanic(fn func(service string, e interface{}, stack []byte)) {
+    s.servant.OnPanic(fn)
+}
+
+// SetPanicStackSize 设置 panic 调用栈的截断长度, size <= 0 时不截断
+func (s *Server) SetPanicStackSize(size int) {
+    s.servant.StackSize = size
+}
+
+func (s *Server) PanicCount() uint64 {
+    return s.servant.PanicCount()
+}
@@ -0,0 +1,102 @@
+package rpc
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+func TestShutdownDrainsRequests(t *testing.T) {
+    entered := make(chan struct{})
+    release := make(chan struct{})
+    s, addr := startServer(t, func(s *Server) {
+        s.Register("slow", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+            close(entered)
+            <-release
+            reply.N = args.N
+            return nil
+        })
+    })
+    c := newTestClient(t, addr)
+
+    result := make(chan error, 1)
+    go func() {
+        var reply echoArgs
+        err := c.Call(context.Background(), "slow", &echoArgs{N: 7}, &reply)
+        if err == nil && reply.N != 7 {
+            t.Errorf("reply = %v", reply)
+        }
+        result <- err
+    }()
+    <-entered
+
+    shutdown := make(chan error, 1)
+    go func() {
+        shutdown <- s.Shutdown(context.Background())
+    }()
+    // 关闭期间连接仍然可用, 但不再接受新的请求
+    var rejected error
+    eventually(t, time.Second, func() bool {
+        rejected = c.Call(context.Background(), "echo", &echoArgs{}, &echoArgs{})
+        return CodeOf(rejected) == CodeUnavailable
+    })
+    // 关闭中的服务器不建议稍后重试
+    if wait, ok := RetryAfter(rejected); ok {
+        t.Fatalf("shutdown rejection has retry-after %v", wait)
+    }
+    select {
+    case err := <-shutdown:
+        t.Fatalf("Shutdown returned %v before the request finished", err)
+    default:
+    }
+
+    close(release)
+    if err := <-result; err != nil {
+        t.Fatalf("in-flight call failed: %v", err)
+    }
+    select {
+    case err := <-shutdown:
+        if err != nil {
+            t.Fatal(err)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("Shutdown did not return")
+    }
+}
+
+func TestShutdownDeadline(t *testing.T) {
+    s, addr := startServer(t, func(s *Server) {
+        s.Register("stuck", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+            <-ctx.Done()
+            return ctx.Err()
+        })
+    })
+    c := newTestClient(t, addr)
+    result := make(chan error, 1)
+    go func() {
+        result <- c.Call(context.Background(), "stuck", &echoArgs{}, &echoArgs{})
+    }()
+    eventually(t, time.Second, func() bool {
+        for _, caller := range s.Connections() {
+            if p, _ := PeerOf(caller); p.requests.len() > 0 {
+                return true
+            }
+        }
+        return false
+    })
+
+    ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+    defer cancel()
+    if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+        t.Fatalf("Shutdown = %v", err)
+    }
+    // 超时后强制关闭连接, 进行中的调用失败
+    select {
+    case err := <-result:
+        if err == nil {
+            t.Fatal("stuck call succeeded")
+        }
+    case <-time.After(time.Second):
+        t.Fatal("stuck call did not fail")
+    }
+}
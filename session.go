@@ -0,0 +1,131 @@
+package rpc
+
+import (
+    "context"
+    "sync"
+)
+
+// Session 保存单个连接的会话数据, 连接关闭时自动清空
+type Session struct {
+    mutex  sync.RWMutex
+    values map[string]interface{}
+}
+
+func newSession() *Session {
+    return &Session{
+        values: make(map[string]interface{}),
+    }
+}
+
+// SessionFromContext 返回处理函数 ctx 中当前连接的会话
+func SessionFromContext(ctx context.Context) (*Session, bool) {
+    p, ok := PeerFromContext(ctx)
+    if !ok || p.Session == nil {
+        return nil, false
+    }
+    return p.Session, true
+}
+
+// SessionOf 返回 Server.OnOpen/OnClose 回调中 Callable 对应连接的会话
+func SessionOf(caller Callable) (*Session, bool) {
+    p, ok := PeerOf(caller)
+    if !ok || p.Session == nil {
+        return nil, false
+    }
+    return p.Session, true
+}
+
+func (s *Session) Get(key string) (interface{}, bool) {
+    s.mutex.RLock()
+    defer s.mutex.RUnlock()
+    val, ok := s.values[key]
+    return val, ok
+}
+
+func (s *Session) Set(key string, val interface{}) {
+    s.mutex.Lock()
+    s.values[key] = val
+    s.mutex.Unlock()
+}
+
+func (s *Session) Delete(key string) {
+    s.mutex.Lock()
+    delete(s.values, key)
+    s.mutex.Unlock()
+}
+
+func (s *Session) Clear() {
+    s.mutex.Lock()
+    s.values = make(map[string]interface{})
+    s.mutex.Unlock()
+}
+
+func (s *Session) Len() int {
+    s.mutex.RLock()
+    defer s.mutex.RUnlock()
+    return len(s.values)
+}
+
+func (s *Session) Range(fn func(key string, val interface{}) bool) {
+    s.mutex.RLock()
+    defer s.mutex.RUnlock()
+    for key, val := range s.values {
+        if !fn(key, val) {
+            return
+        }
+    }
+}
+
+func (s *Session) GetString(key string) (string, bool) {
+    val, ok := s.Get(key)
+    if !ok {
+        return "", false
+    }
+    v, ok := val.(string)
+    return v, ok
+}
+
+func (s *Session) GetInt(key string) (int, bool) {
+    val, ok := s.Get(key)
+    if !ok {
+        return 0, false
+    }
+    v, ok := val.(int)
+    return v, ok
+}
+
+func (s *Session) GetInt64(key string) (int64, bool) {
+    val, ok := s.Get(key)
+    if !ok {
+        return 0, false
+    }
+    v, ok := val.(int64)
+    return v, ok
+}
+
+func (s *Session) GetUint64(key string) (uint64, bool) {
+    val, ok := s.Get(key)
+    if !ok {
+        return 0, false
+    }
+    v, ok := val.(uint64)
+    return v, ok
+}
+
+func (s *Session) GetFloat64(key string) (float64, bool) {
+    val, ok := s.Get(key)
+    if !ok {
+        return 0, false
+    }
+    v, ok := val.(float64)
+    return v, ok
+}
+
+func (s *Session) GetBool(key string) (bool, bool) {
+    val, ok := s.Get(key)
+    if !ok {
+        return false, false
+    }
+    v, ok := val.(bool)
+    return v, ok
+}
@@ -0,0 +1,78 @@
+package rpc
+
+import (
+    "context"
+    "testing"
+)
+
+func TestSessionPerConnection(t *testing.T) {
+    closed := make(chan int, 2)
+    _, addr := startServer(t, func(s *Server) {
+        s.Register("incr", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+            session, _ := SessionFromContext(ctx)
+            n, _ := session.GetInt("n")
+            n += args.N
+            session.Set("n", n)
+            reply.N = n
+            return nil
+        })
+        s.OnClose(func(caller Callable) {
+            session, _ := SessionOf(caller)
+            n, _ := session.GetInt("n")
+            closed <- n
+        })
+    })
+    a := newTestClient(t, addr)
+    b := newTestClient(t, addr)
+
+    var reply echoArgs
+    for i := 0; i < 3; i++ {
+        if err := a.Call(context.Background(), "incr", &echoArgs{N: 1}, &reply); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if reply.N != 3 {
+        t.Fatalf("a: n = %d, want 3", reply.N)
+    }
+    if err := b.Call(context.Background(), "incr", &echoArgs{N: 10}, &reply); err != nil {
+        t.Fatal(err)
+    }
+    if reply.N != 10 {
+        t.Fatalf("b: n = %d, want 10", reply.N)
+    }
+    a.Close()
+    if n := <-closed; n != 3 {
+        t.Fatalf("OnClose session n = %d, want 3", n)
+    }
+}
+
+func TestSessionTypedGetters(t *testing.T) {
+    s := newSession()
+    s.Set("s", "x")
+    s.Set("i", 1)
+    s.Set("f", 1.5)
+    s.Set("b", true)
+    if v, ok := s.GetString("s"); !ok || v != "x" {
+        t.Errorf("GetString = %v %v", v, ok)
+    }
+    if _, ok := s.GetString("i"); ok {
+        t.Errorf("GetString on int should fail")
+    }
+    if v, ok := s.GetInt("i"); !ok || v != 1 {
+        t.Errorf("GetInt = %v %v", v, ok)
+    }
+    if v, ok := s.GetFloat64("f"); !ok || v != 1.5 {
+        t.Errorf("GetFloat64 = %v %v", v, ok)
+    }
+    if v, ok := s.GetBool("b"); !ok || !v {
+        t.Errorf("GetBool = %v %v", v, ok)
+    }
+    s.Delete("s")
+    if s.Len() != 3 {
+        t.Errorf("Len = %d", s.Len())
+    }
+    s.Clear()
+    if s.Len() != 0 {
+        t.Errorf("Len after Clear = %d", s.Len())
+    }
+}
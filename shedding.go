@@ -0,0 +1,187 @@
+package rpc
+
+import (
+    "context"
+    "github.com/DGHeroin/rpc/pb"
+    "runtime"
+    "strconv"
+    "sync"
+    "time"
+)
+
+const (
+    MetadataPriority = "rpc-priority"
+)
+
+// Priority 是请求优先级, 过载时低优先级的请求先被拒绝
+type Priority int
+
+const (
+    PriorityLow Priority = iota
+    PriorityNormal
+    PriorityHigh
+    PriorityCritical // 不会被丢弃
+)
+
+type (
+    // LoadShedder 按 CoDel 的思路根据排队延迟自适应地丢弃请求
+    // 并发达到 MaxConcurrency 时请求排队等待, 每个 Interval 内的最小排队延迟超过 TargetDelay 时视为过载,
+    // 逐级提高丢弃的优先级门槛, 先丢弃 PriorityLow, 仍然过载再丢弃 PriorityNormal, 恢复后逐级降低.
+    // MaxConcurrency <= 0 时取 GOMAXPROCS 的 32 倍
+    LoadShedder struct {
+        TargetDelay    time.Duration
+        Interval       time.Duration
+        MaxConcurrency int
+        once           sync.Once
+        sem            chan struct{}
+        mutex          sync.Mutex
+        intervalStart  time.Time
+        minDelay       time.Duration
+        samples        int
+        level          Priority
+        inflight       int
+        shed           uint64
+    }
+    ShedderStats struct {
+        Level    Priority
+        Inflight int
+        Shed     uint64
+    }
+)
+
+// WithPriority 返回携带请求优先级的 ctx
+func WithPriority(ctx context.Context, p Priority) context.Context {
+    return AppendMetadata(ctx, MetadataPriority, strconv.Itoa(int(p)))
+}
+
+// PriorityOf 返回处理中的请求的优先级, 未设置时为 PriorityNormal
+func PriorityOf(ctx context.Context) Priority {
+    return parsePriority(IncomingMetadata(ctx)[MetadataPriority])
+}
+
+func parsePriority(val string) Priority {
+    n, err := strconv.Atoi(val)
+    if err != nil {
+        return PriorityNormal
+    }
+    return Priority(n)
+}
+
+func requestPriority(msg *pb.Message) Priority {
+    val, _ := dictGet(msg.GetDict(), MetadataPriority)
+    return parsePriority(string(val))
+}
+
+func NewLoadShedder() *LoadShedder {
+    return &LoadShedder{
+        TargetDelay: 5 * time.Millisecond,
+        Interval:    100 * time.Millisecond,
+    }
+}
+
+func (l *LoadShedder) init() {
+    l.once.Do(func() {
+        if l.TargetDelay <= 0 {
+            l.TargetDelay = 5 * time.Millisecond
+        }
+        if l.Interval <= 0 {
+            l.Interval = 100 * time.Millisecond
+        }
+        if l.MaxConcurrency <= 0 {
+            l.MaxConcurrency = runtime.GOMAXPROCS(0) * 32
+        }
+        l.sem = make(chan struct{}, l.MaxConcurrency)
+        l.intervalStart = time.Now()
+        l.level = PriorityLow
+    })
+}
+
+func (l *LoadShedder) Stats() ShedderStats {
+    l.init()
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+    return ShedderStats{Level: l.level, Inflight: l.inflight, Shed: l.shed}
+}
+
+// acquire 在处理请求前调用, arrived 为请求帧到达的时间
+// 返回 false 时请求被丢弃, 否则处理结束后须调用 release
+func (l *LoadShedder) acquire(ctx context.Context, arrived time.Time, p Priority) (release func(), ok bool) {
+    l.init()
+    if l.rejects(p) {
+        return nil, false
+    }
+    select {
+    case l.sem <- struct{}{}:
+    case <-ctx.Done():
+        l.reject()
+        return nil, false
+    }
+    delay := time.Since(arrived)
+    l.mutex.Lock()
+    l.observe(delay)
+    // 排队期间门槛已经高于请求的优先级, 或者过载时排队过久的请求在出队时丢弃, 避免处理已经没有意义的请求
+    drop := p < PriorityCritical && (p < l.level || (l.level > PriorityLow && delay > l.Interval))
+    if drop {
+        l.shed++
+    } else {
+        l.inflight++
+    }
+    l.mutex.Unlock()
+    if drop {
+        <-l.sem
+        return nil, false
+    }
+    return func() {
+        l.mutex.Lock()
+        l.inflight--
+        l.mutex.Unlock()
+        <-l.sem
+    }, true
+}
+
+func (l *LoadShedder) rejects(p Priority) bool {
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+    l.roll(time.Now())
+    if p < PriorityCritical && p < l.level {
+        l.shed++
+        return true
+    }
+    return false
+}
+
+func (l *LoadShedder) reject() {
+    l.mutex.Lock()
+    l.shed++
+    l.mutex.Unlock()
+}
+
+func (l *LoadShedder) observe(delay time.Duration) {
+    if l.samples == 0 || delay < l.minDelay {
+        l.minDelay = delay
+    }
+    l.samples++
+    l.roll(time.Now())
+}
+
+// roll 在一个 Interval 结束时根据最小排队延迟调整丢弃门槛
+func (l *LoadShedder) roll(now time.Time) {
+    if now.Sub(l.intervalStart) < l.Interval {
+        return
+    }
+    overloaded := l.samples > 0 && l.minDelay > l.TargetDelay
+    if len(l.sem) == cap(l.sem) && l.samples == 0 {
+        // 并发已满且整个周期没有请求出队
+        overloaded = true
+    }
+    if overloaded {
+        if l.level < PriorityCritical {
+            l.level++
+        }
+    } else if l.level > PriorityLow {
+        l.level--
+    }
+    l.intervalStart = now
+    l.minDelay = 0
+    l.samples = 0
+}
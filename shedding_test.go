@@ -0,0 +1,152 @@
+package rpc
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+func TestParsePriority(t *testing.T) {
+    tests := []struct {
+        val  string
+        want Priority
+    }{
+        {"", PriorityNormal},
+        {"x", PriorityNormal},
+        {"0", PriorityLow},
+        {"2", PriorityHigh},
+        {"3", PriorityCritical},
+    }
+    for _, tt := range tests {
+        if got := parsePriority(tt.val); got != tt.want {
+            t.Errorf("parsePriority(%q) = %v, want %v", tt.val, got, tt.want)
+        }
+    }
+}
+
+func TestShedderQueuesWhenFull(t *testing.T) {
+    l := &LoadShedder{MaxConcurrency: 1, TargetDelay: time.Second, Interval: time.Second}
+    ctx := context.Background()
+    release, ok := l.acquire(ctx, time.Now(), PriorityNormal)
+    if !ok {
+        t.Fatal("first request rejected")
+    }
+    // 并发已满时各优先级的请求都排队等待
+    acquired := make(chan func(), 1)
+    go func() {
+        r, ok := l.acquire(ctx, time.Now(), PriorityLow)
+        if !ok {
+            t.Error("queued request rejected")
+        }
+        acquired <- r
+    }()
+    select {
+    case <-acquired:
+        t.Fatal("request did not wait")
+    case <-time.After(20 * time.Millisecond):
+    }
+    release()
+    release = <-acquired
+
+    // 排队时 ctx 结束
+    timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+    defer cancel()
+    if _, ok := l.acquire(timeout, time.Now(), PriorityCritical); ok {
+        t.Fatal("request admitted while full")
+    }
+    release()
+    if st := l.Stats(); st.Shed != 1 || st.Inflight != 0 || st.Level != PriorityLow {
+        t.Fatalf("stats = %+v", st)
+    }
+}
+
+// 排队延迟超过目标时先丢弃 PriorityLow, PriorityNormal 仍然被处理
+func TestShedderShedsLowBeforeNormal(t *testing.T) {
+    l := &LoadShedder{MaxConcurrency: 1, TargetDelay: time.Millisecond, Interval: 50 * time.Millisecond}
+    ctx := context.Background()
+    release, ok := l.acquire(ctx, time.Now(), PriorityNormal)
+    if !ok {
+        t.Fatal("first request rejected")
+    }
+    // 等第一个周期结束, 新周期里只有排队的请求
+    time.Sleep(60 * time.Millisecond)
+    acquired := make(chan func(), 1)
+    go func() {
+        r, ok := l.acquire(ctx, time.Now(), PriorityNormal)
+        if !ok {
+            t.Error("queued normal request shed")
+        }
+        acquired <- r
+    }()
+    time.Sleep(30 * time.Millisecond)
+    release()
+    release = <-acquired
+
+    // 周期结束时最小排队延迟约 30ms, 门槛升到 PriorityNormal
+    time.Sleep(30 * time.Millisecond)
+    if _, ok := l.acquire(ctx, time.Now(), PriorityLow); ok {
+        t.Fatal("low priority request admitted while overloaded")
+    }
+    if st := l.Stats(); st.Level != PriorityNormal || st.Shed != 1 {
+        t.Fatalf("stats = %+v", st)
+    }
+    release()
+    release, ok = l.acquire(ctx, time.Now(), PriorityNormal)
+    if !ok {
+        t.Fatal("normal priority request shed")
+    }
+    release()
+
+    // 不再排队后门槛逐级降低
+    time.Sleep(60 * time.Millisecond)
+    release, ok = l.acquire(ctx, time.Now(), PriorityLow)
+    if !ok {
+        t.Fatal("low priority request shed after recovery")
+    }
+    release()
+}
+
+func TestShedderDefaultConcurrency(t *testing.T) {
+    l := NewLoadShedder()
+    release, ok := l.acquire(context.Background(), time.Now(), PriorityLow)
+    if !ok {
+        t.Fatal("request rejected")
+    }
+    release()
+    if l.MaxConcurrency <= 0 {
+        t.Fatalf("MaxConcurrency = %d", l.MaxConcurrency)
+    }
+}
+
+func TestServerLoadShedding(t *testing.T) {
+    shedder := &LoadShedder{MaxConcurrency: 1, Interval: time.Minute}
+    _, addr := startServer(t, func(s *Server) {
+        s.Shedder = shedder
+        s.Register("priority", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+            reply.N = int(PriorityOf(ctx))
+            return nil
+        })
+    })
+    shedder.init()
+    shedder.mutex.Lock()
+    shedder.level = PriorityNormal
+    shedder.mutex.Unlock()
+    c := newTestClient(t, addr)
+
+    err := c.Call(WithPriority(context.Background(), PriorityLow), "echo", &echoArgs{}, &echoArgs{})
+    if CodeOf(err) != CodeResourceExhausted {
+        t.Fatalf("err = %v, want ResourceExhausted", err)
+    }
+    if _, ok := RetryAfter(err); !ok {
+        t.Fatal("no retry-after on shed reply")
+    }
+    for _, p := range []Priority{PriorityNormal, PriorityHigh} {
+        var reply echoArgs
+        if err := c.Call(WithPriority(context.Background(), p), "priority", &echoArgs{}, &reply); err != nil {
+            t.Fatal(err)
+        }
+        if Priority(reply.N) != p {
+            t.Fatalf("PriorityOf = %d, want %d", reply.N, p)
+        }
+    }
+}
@@ -0,0 +1,138 @@
+package rpc
+
+import (
+    "context"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/trace"
+)
+
+const (
+    instrumentationName = "github.com/DGHeroin/rpc"
+)
+
+var (
+    attrService      = attribute.Key("rpc.service")
+    attrPeerAddr     = attribute.Key("net.peer.addr")
+    attrRequestSize  = attribute.Key("rpc.request.size")
+    attrResponseSize = attribute.Key("rpc.response.size")
+    attrCode         = attribute.Key("rpc.code")
+)
+
+// Tracing 配置 OpenTelemetry 链路追踪, 追踪上下文通过请求元数据传递
+// Provider 和 Propagator 为空时使用 otel 的全局设置
+type Tracing struct {
+    Provider   trace.TracerProvider
+    Propagator propagation.TextMapPropagator
+}
+
+// metadataCarrier 让 Propagator 读写请求元数据
+type metadataCarrier Metadata
+
+func (c metadataCarrier) Get(key string) string {
+    return c[key]
+}
+
+func (c metadataCarrier) Set(key string, value string) {
+    c[key] = value
+}
+
+func (c metadataCarrier) Keys() []string {
+    keys := make([]string, 0, len(c))
+    for k := range c {
+        keys = append(keys, k)
+    }
+    return keys
+}
+
+func (t *Tracing) tracer() trace.Tracer {
+    provider := t.Provider
+    if provider == nil {
+        provider = otel.GetTracerProvider()
+    }
+    return provider.Tracer(instrumentationName)
+}
+
+func (t *Tracing) propagator() propagation.TextMapPropagator {
+    if t.Propagator != nil {
+        return t.Propagator
+    }
+    return otel.GetTextMapPropagator()
+}
+
+// startClient 为发出的调用创建 span, 并将追踪上下文写入请求元数据
+func (t *Tracing) startClient(ctx context.Context, service string, remote string) (context.Context, trace.Span) {
+    if t == nil {
+        return ctx, noopSpan
+    }
+    ctx, span := t.tracer().Start(ctx, service,
+        trace.WithSpanKind(trace.SpanKindClient),
+        trace.WithAttributes(attrService.String(service), attrPeerAddr.String(remote)),
+    )
+    md := Metadata{}
+    t.propagator().Inject(ctx, metadataCarrier(md))
+    if len(md) > 0 {
+        ctx = WithMetadata(ctx, md)
+    }
+    return ctx, span
+}
+
+// startServer 为处理函数创建 span, 父 span 取自请求元数据
+func (t *Tracing) startServer(ctx context.Context, service string, requestSize int) (context.Context, trace.Span) {
+    if t == nil {
+        return ctx, noopSpan
+    }
+    if md := IncomingMetadata(ctx); md != nil {
+        ctx = t.propagator().Extract(ctx, metadataCarrier(md))
+    }
+    attrs := []attribute.KeyValue{
+        attrService.String(service),
+        attrRequestSize.Int(requestSize),
+    }
+    if p, ok := PeerFromContext(ctx); ok && p.RemoteAddr != nil {
+        attrs = append(attrs, attrPeerAddr.String(p.RemoteAddr.String()))
+    }
+    return t.tracer().Start(ctx, service,
+        trace.WithSpanKind(trace.SpanKindServer),
+        trace.WithAttributes(attrs...),
+    )
+}
+
+var noopSpan = trace.SpanFromContext(context.Background())
+
+func endSpan(span trace.Span, err error) {
+    if !span.IsRecording() {
+        span.End()
+        return
+    }
+    code := CodeOf(err)
+    span.SetAttributes(attrCode.String(code.String()))
+    if err != nil {
+        span.SetStatus(codes.Error, err.Error())
+    }
+    span.End()
+}
+
+// endCall 记录调用的请求和响应大小后结束 span
+func endCall(span trace.Span, call *Call, err error) {
+    span.SetAttributes(attrRequestSize.Int(call.sent), attrResponseSize.Int(call.recv))
+    endSpan(span, err)
+}
+
+func (s *Servant) SetTracing(t *Tracing) {
+    s.tracing = t
+}
+
+func (s *Server) SetTracing(t *Tracing) {
+    s.servant.SetTracing(t)
+}
+
+func (client *Client) SetTracing(t *Tracing) {
+    client.servant.SetTracing(t)
+}
+
+func (c *BalancedClient) SetTracing(t *Tracing) {
+    c.servant.SetTracing(t)
+}
@@ -0,0 +1,114 @@
+package rpc
+
+import (
+    "context"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/propagation"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    "go.opentelemetry.io/otel/sdk/trace/tracetest"
+    "go.opentelemetry.io/otel/trace"
+    "testing"
+)
+
+func spanAttrs(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+    attrs := make(map[attribute.Key]attribute.Value)
+    for _, kv := range span.Attributes {
+        attrs[kv.Key] = kv.Value
+    }
+    return attrs
+}
+
+func TestTracingSpans(t *testing.T) {
+    exporter := tracetest.NewInMemoryExporter()
+    provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+    tracing := &Tracing{Provider: provider, Propagator: propagation.TraceContext{}}
+
+    _, addr := startServer(t, func(s *Server) {
+        s.SetTracing(tracing)
+        s.Register("greet", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+            p, _ := PeerFromContext(ctx)
+            if err := p.Callable.Call(ctx, "ping", args, &echoArgs{}); err != nil {
+                return err
+            }
+            reply.Name = args.Name
+            return nil
+        })
+    })
+    c := newTestClient(t, addr)
+    c.SetTracing(tracing)
+    c.Register("ping", func(ctx context.Context, args *echoArgs, reply *echoArgs) error {
+        return nil
+    })
+
+    if err := c.Call(context.Background(), "greet", &echoArgs{Name: "hi"}, &echoArgs{}); err != nil {
+        t.Fatal(err)
+    }
+    if err := c.Call(context.Background(), "missing", &echoArgs{}, &echoArgs{}); CodeOf(err) != CodeNotFound {
+        t.Fatalf("err = %v", err)
+    }
+
+    spans := make(map[string]tracetest.SpanStub)
+    for _, span := range exporter.GetSpans() {
+        spans[span.Name+"/"+span.SpanKind.String()] = span
+    }
+    client, ok1 := spans["greet/client"]
+    server, ok2 := spans["greet/server"]
+    callback, ok3 := spans["ping/client"]
+    callbackServer, ok4 := spans["ping/server"]
+    if !ok1 || !ok2 || !ok3 || !ok4 {
+        t.Fatalf("spans = %v", exporter.GetSpans())
+    }
+
+    // 服务端 span 的父 span 是客户端 span, 回调的 span 是服务端 span 的子 span
+    parents := []struct {
+        name          string
+        child, parent tracetest.SpanStub
+    }{
+        {"server", server, client},
+        {"callback", callback, server},
+        {"callback server", callbackServer, callback},
+    }
+    for _, p := range parents {
+        if p.child.Parent.SpanID() != p.parent.SpanContext.SpanID() {
+            t.Errorf("%s: parent = %s, want %s", p.name, p.child.Parent.SpanID(), p.parent.SpanContext.SpanID())
+        }
+        if p.child.SpanContext.TraceID() != client.SpanContext.TraceID() {
+            t.Errorf("%s: trace id differs", p.name)
+        }
+    }
+    if !server.Parent.IsRemote() {
+        t.Error("server parent should be remote")
+    }
+
+    for _, span := range []tracetest.SpanStub{client, server, callback} {
+        attrs := spanAttrs(span)
+        for _, key := range []attribute.Key{attrService, attrPeerAddr, attrRequestSize, attrCode} {
+            if _, ok := attrs[key]; !ok {
+                t.Errorf("%s/%s: missing attribute %s", span.Name, span.SpanKind, key)
+            }
+        }
+        if attrs[attrCode].AsString() != CodeOK.String() {
+            t.Errorf("%s/%s: code = %s", span.Name, span.SpanKind, attrs[attrCode].AsString())
+        }
+    }
+    if attrs := spanAttrs(client); attrs[attrRequestSize].AsInt64() <= 0 || attrs[attrResponseSize].AsInt64() <= 0 {
+        t.Errorf("client sizes = %v", client.Attributes)
+    }
+    if attrs := spanAttrs(client); attrs[attrPeerAddr].AsString() != addr {
+        t.Errorf("client peer = %s", attrs[attrPeerAddr].AsString())
+    }
+
+    missing, ok := spans["missing/client"]
+    if !ok {
+        t.Fatal("no span for failed call")
+    }
+    if code := spanAttrs(missing)[attrCode].AsString(); code != CodeNotFound.String() {
+        t.Errorf("failed call code = %s", code)
+    }
+    if missing.Status.Code.String() != "Error" {
+        t.Errorf("failed call status = %v", missing.Status)
+    }
+    if missing.SpanKind != trace.SpanKindClient {
+        t.Errorf("kind = %v", missing.SpanKind)
+    }
+}